@@ -0,0 +1,158 @@
+// Copyright 2023, DASH-Industry Forum. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.md file.
+
+package app
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"strings"
+
+	"github.com/Dash-Industry-Forum/livesim2/pkg/drm"
+	"github.com/Dash-Industry-Forum/livesim2/pkg/scte35"
+	m "github.com/Eyevinn/dash-mpd/mpd"
+)
+
+// periodItvl is a period interval in seconds relative to availabilityStartTime.
+type periodItvl struct {
+	id     string
+	nr     int
+	startS int
+	endS   int
+	isAd   bool
+}
+
+// openEndS is used as end of the last period when it has no known end.
+const openEndS = math.MaxInt32
+
+// setAdAsset sets the configured ad asset in cfg.
+// If no ad asset is configured, the live asset itself is used for the ads.
+func (s *Server) setAdAsset(cfg *ResponseConfig) error {
+	if s.Cfg.AdAsset == "" {
+		return nil
+	}
+	adAsset, ok := s.assetMgr.findAsset(s.Cfg.AdAsset)
+	if !ok {
+		return fmt.Errorf("ad asset %q not found", s.Cfg.AdAsset)
+	}
+	cfg.adAsset = adAsset
+	cfg.adMPDName = path.Base(s.Cfg.AdAsset)
+	return nil
+}
+
+// adInsertionItvls returns the content and ad period intervals overlapping [windowStartS, nowS].
+// The ad breaks are the SCTE-35 splice inserts announced in the video segments.
+func adInsertionItvls(sched scte35.Schedule, windowStartS, nowS int) []periodItvl {
+	// There is at least one ad break start per schedule period, so looking back one
+	// period is enough to find the start of the first period.
+	lookBackS := max(windowStartS-sched.PeriodS, 0)
+	breaks := sched.AdBreaks(uint64(lookBackS), uint64(nowS+1), 1)
+	itvls := make([]periodItvl, 0, 2*len(breaks)+1)
+	contentStartS := lookBackS
+	for _, b := range breaks {
+		adStartS, adEndS := int(b.Start), int(b.End())
+		if adStartS > contentStartS {
+			itvls = append(itvls, periodItvl{id: fmt.Sprintf("P%d", contentStartS), startS: contentStartS, endS: adStartS})
+		}
+		itvls = append(itvls, periodItvl{id: fmt.Sprintf("ad%d", adStartS), startS: adStartS, endS: adEndS, isAd: true})
+		contentStartS = adEndS
+	}
+	if contentStartS <= nowS {
+		itvls = append(itvls, periodItvl{id: fmt.Sprintf("P%d", contentStartS), startS: contentStartS, endS: openEndS})
+	}
+	firstIdx := 0
+	for i, itvl := range itvls {
+		if itvl.endS > windowStartS {
+			firstIdx = i
+			break
+		}
+	}
+	return itvls[firstIdx:]
+}
+
+// adURLCfgParts returns the URL configuration parts for the ad segments.
+// The ad insertion and SCTE-35 parts are removed, since the ads should not carry any cues.
+func adURLCfgParts(cfg *ResponseConfig) []string {
+	parts := make([]string, 0, cfg.URLContentIdx)
+	for _, part := range cfg.URLParts[:cfg.URLContentIdx] {
+		key, _, _ := strings.Cut(part, "_")
+		switch key {
+		case "insertad", "scte35", "scte35cmd", "scte35sig":
+			continue
+		}
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+// insertAdPeriods splits the single-period MPD into content and ad periods.
+// The ad periods are generated from the ad asset (cfg.adAsset or a) and refer
+// to its segments via an absolute BaseURL.
+func insertAdPeriods(mpd *m.MPD, a *asset, cfg *ResponseConfig, drmCfg *drm.DrmConfig, wTimes wrapTimes) error {
+	if len(mpd.Periods) != 1 {
+		return fmt.Errorf("not exactly one period in the MPD")
+	}
+	adAsset, adMPDName := cfg.adAsset, cfg.adMPDName
+	if adAsset == nil {
+		adAsset = a
+		adMPDName = path.Base(cfg.URLParts[len(cfg.URLParts)-1])
+	}
+	adCfg := *cfg
+	adCfg.InsertAdFlag = false
+	adCfg.SCTE35 = nil
+	adCfg.AddLocationFlag = false
+	adCfg.PatchTTL = 0
+	adCfg.TimeSubsStpp = nil
+	adCfg.TimeSubsWvtt = nil
+	adMPD, err := LiveMPD(adAsset, adMPDName, &adCfg, drmCfg, wTimes.nowMS)
+	if err != nil {
+		return fmt.Errorf("ad asset liveMPD: %w", err)
+	}
+	adBaseURL := cfg.Host + strings.Join(adURLCfgParts(cfg), "/") + "/" + adAsset.AssetPath + "/"
+
+	astMS := cfg.StartTimeS * 1000
+	periodOffsetS := cfg.getPeriodOffsetS()
+	windowStartS := max((wTimes.startTimeMS-astMS)/1000, periodOffsetS)
+	nowS := max((wTimes.nowMS-astMS)/1000, windowStartS)
+	itvls := adInsertionItvls(*cfg.SCTE35, windowStartS, nowS)
+	if len(itvls) > 0 && itvls[0].startS < periodOffsetS {
+		// No content before the period offset
+		itvls[0].startS = periodOffsetS
+		if !itvls[0].isAd {
+			itvls[0].id = fmt.Sprintf("P%d", periodOffsetS)
+		}
+	}
+	inPeriod := mpd.Periods[0]
+	mpd.Periods = nil
+	for _, itvl := range itvls {
+		if itvl.isAd {
+			for _, aa := range []*asset{a, adAsset} {
+				if itvl.startS*1000%aa.SegmentDurMS != 0 || itvl.endS*1000%aa.SegmentDurMS != 0 {
+					return fmt.Errorf("ad break %ds-%ds not aligned with %dms segments of %s",
+						itvl.startS, itvl.endS, aa.SegmentDurMS, aa.AssetPath)
+				}
+			}
+			p, err := createPeriod(adMPD.Periods[0], cfg, itvl.id, itvl.startS, itvl.endS)
+			if err != nil {
+				return err
+			}
+			if len(p.BaseURLs) == 0 {
+				p.BaseURLs = append(p.BaseURLs, m.NewBaseURL(adBaseURL))
+			} else {
+				for _, b := range p.BaseURLs {
+					b.Value = m.AnyURI(adBaseURL) + b.Value
+				}
+			}
+			mpd.AppendPeriod(p)
+			continue
+		}
+		p, err := createPeriod(inPeriod, cfg, itvl.id, itvl.startS, itvl.endS)
+		if err != nil {
+			return err
+		}
+		mpd.AppendPeriod(p)
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+// Copyright 2023, DASH-Industry Forum. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.md file.
+
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dash-Industry-Forum/livesim2/pkg/logging"
+	"github.com/Dash-Industry-Forum/livesim2/pkg/scte35"
+	m "github.com/Eyevinn/dash-mpd/mpd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdInsertionItvls(t *testing.T) {
+	cases := []struct {
+		desc         string
+		perMinute    int
+		schedule     string
+		windowStartS int
+		nowS         int
+		wantedItvls  []periodItvl
+	}{
+		{
+			desc:         "start of session",
+			perMinute:    1,
+			windowStartS: 0,
+			nowS:         20,
+			wantedItvls: []periodItvl{
+				{id: "P0", startS: 0, endS: 10},
+				{id: "ad10", startS: 10, endS: 30, isAd: true},
+			},
+		},
+		{
+			desc:         "one ad per minute",
+			perMinute:    1,
+			windowStartS: 941,
+			nowS:         1001,
+			wantedItvls: []periodItvl{
+				{id: "P930", startS: 930, endS: 970},
+				{id: "ad970", startS: 970, endS: 990, isAd: true},
+				{id: "P990", startS: 990, endS: openEndS},
+			},
+		},
+		{
+			desc:         "three ads per minute",
+			perMinute:    3,
+			windowStartS: 100,
+			nowS:         130,
+			wantedItvls: []periodItvl{
+				{id: "ad96", startS: 96, endS: 106, isAd: true},
+				{id: "ad106", startS: 106, endS: 116, isAd: true},
+				{id: "P116", startS: 116, endS: 130},
+				{id: "ad130", startS: 130, endS: 140, isAd: true},
+			},
+		},
+		{
+			desc:         "two-minute break every 15 minutes",
+			schedule:     "period:900,offset:60,dur:120",
+			windowStartS: 800,
+			nowS:         1000,
+			wantedItvls: []periodItvl{
+				{id: "P180", startS: 180, endS: 960},
+				{id: "ad960", startS: 960, endS: 1080, isAd: true},
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			var sched scte35.Schedule
+			var err error
+			if tc.schedule != "" {
+				sched, err = scte35.ParseSchedule(tc.schedule)
+			} else {
+				sched, err = scte35.PerMinuteSchedule(tc.perMinute)
+			}
+			require.NoError(t, err)
+			itvls := adInsertionItvls(sched, tc.windowStartS, tc.nowS)
+			require.Equal(t, tc.wantedItvls, itvls)
+		})
+	}
+}
+
+func TestInsertAdPeriods(t *testing.T) {
+	vodFS := os.DirFS("testdata/assets")
+	am := newAssetMgr(vodFS, "", false)
+	logger := slog.Default()
+	err := am.discoverAssets(logger)
+	require.NoError(t, err)
+	asset, ok := am.findAsset("testpic_2s")
+	require.True(t, ok)
+
+	nowMS := 1001_000
+	cases := []struct {
+		desc            string
+		url             string
+		wantedStartNrs  []int
+		wantedVideoPTOs []int
+		wantedAdBaseURL string
+	}{
+		{
+			desc:            "$Number$",
+			url:             "/livesim2/insertad_1/scte35_1/testpic_2s/Manifest.mpd",
+			wantedStartNrs:  []int{465, 485, 495},
+			wantedVideoPTOs: []int{930, 970, 990},
+			wantedAdBaseURL: "http://localhost/livesim2/testpic_2s/",
+		},
+		{
+			desc:            "SegmentTimeline with $Time$",
+			url:             "/livesim2/insertad_1/scte35_1/segtimeline_1/testpic_2s/Manifest.mpd",
+			wantedVideoPTOs: []int{930 * 90000, 970 * 90000, 990 * 90000},
+			wantedAdBaseURL: "http://localhost/livesim2/segtimeline_1/testpic_2s/",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			cfg, err := processURLCfg(tc.url, nowMS)
+			require.NoError(t, err)
+			cfg.Host = "http://localhost"
+			liveMPD, err := LiveMPD(asset, "Manifest.mpd", cfg, nil, nowMS)
+			require.NoError(t, err)
+			require.Equal(t, 3, len(liveMPD.Periods))
+			wantedIDs := []string{"P930", "ad970", "P990"}
+			wantedStarts := []int{930, 970, 990}
+			for i, p := range liveMPD.Periods {
+				require.Equal(t, wantedIDs[i], p.Id)
+				require.Equal(t, m.Seconds2DurPtr(wantedStarts[i]), p.Start)
+				for _, as := range p.AdaptationSets {
+					if as.ContentType != "video" {
+						continue
+					}
+					st := as.SegmentTemplate
+					require.Equal(t, tc.wantedVideoPTOs[i], int(*st.PresentationTimeOffset))
+					if tc.wantedStartNrs != nil {
+						require.Equal(t, tc.wantedStartNrs[i], int(*st.StartNumber))
+					}
+				}
+			}
+			adPeriod := liveMPD.Periods[1]
+			require.Equal(t, 1, len(adPeriod.BaseURLs))
+			require.Equal(t, tc.wantedAdBaseURL, string(adPeriod.BaseURLs[0].Value))
+			require.Equal(t, 0, len(liveMPD.Periods[0].BaseURLs))
+		})
+	}
+}
+
+func TestSCTE35Schedules(t *testing.T) {
+	cfg := ServerConfig{
+		VodRoot:         "testdata/assets",
+		TimeoutS:        0,
+		LogFormat:       logging.LogDiscard,
+		SCTE35Schedules: map[string]string{"broadcast": "period:900,offset:60,dur:120,announce:8,repeats:2"},
+	}
+	err := logging.InitSlog(cfg.LogLevel, cfg.LogFormat)
+	require.NoError(t, err)
+	server, err := SetupServer(context.Background(), &cfg)
+	require.NoError(t, err)
+	ts := httptest.NewServer(server.Router)
+	defer ts.Close()
+
+	testCases := []struct {
+		desc         string
+		url          string
+		wantedCode   int
+		wantedSCTE35 bool // InbandEventStream in MPD or emsg in segment
+		wantedInBody string
+	}{
+		{desc: "named schedule MPD", url: "/livesim2/scte35_broadcast/testpic_2s/Manifest.mpd", wantedCode: http.StatusOK,
+			wantedSCTE35: true},
+		{desc: "unknown schedule", url: "/livesim2/scte35_other/testpic_2s/Manifest.mpd", wantedCode: http.StatusBadRequest},
+		{desc: "bad inline schedule", url: "/livesim2/scte35_period:60/testpic_2s/Manifest.mpd", wantedCode: http.StatusBadRequest},
+		{desc: "first announcement", url: "/livesim2/scte35_broadcast/testpic_2s/V300/25.m4s", wantedCode: http.StatusOK, wantedSCTE35: true},
+		{desc: "repeated announcement", url: "/livesim2/scte35_broadcast/testpic_2s/V300/27.m4s", wantedCode: http.StatusOK, wantedSCTE35: true},
+		{desc: "no announcement", url: "/livesim2/scte35_broadcast/testpic_2s/V300/26.m4s", wantedCode: http.StatusOK},
+		{desc: "inline schedule", url: "/livesim2/scte35_period:900,offset:60,dur:120/testpic_2s/V300/26.m4s", wantedCode: http.StatusOK, wantedSCTE35: true},
+		// The break at 70s is announced in segment 31 [62s, 64s) and its end at 90s in segment 41 [82s, 84s)
+		{desc: "time_signal cue-out", url: "/livesim2/scte35_1/scte35cmd_providerpo/testpic_2s/V300/31.m4s", wantedCode: http.StatusOK,
+			wantedSCTE35: true, wantedInBody: "urn:livesim2:break:70"},
+		{desc: "time_signal cue-in", url: "/livesim2/scte35_1/scte35cmd_providerpo/testpic_2s/V300/41.m4s", wantedCode: http.StatusOK,
+			wantedSCTE35: true, wantedInBody: "urn:livesim2:break:70"},
+		{desc: "unknown command", url: "/livesim2/scte35_1/scte35cmd_other/testpic_2s/Manifest.mpd", wantedCode: http.StatusBadRequest},
+		{desc: "command without scte35", url: "/livesim2/scte35cmd_providerpo/testpic_2s/Manifest.mpd", wantedCode: http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			resp, body := testFullRequest(t, ts, "GET", tc.url+"?nowMS=100000", nil)
+			require.Equal(t, tc.wantedCode, resp.StatusCode)
+			if tc.wantedCode != http.StatusOK {
+				return
+			}
+			if strings.HasSuffix(tc.url, ".mpd") {
+				mpd, err := m.ReadFromString(string(body))
+				require.NoError(t, err)
+				hasInband := false
+				for _, as := range mpd.Periods[0].AdaptationSets {
+					for _, ies := range as.InbandEventStreams {
+						hasInband = hasInband || ies.SchemeIdUri == scte35.SchemeIDURI
+					}
+				}
+				require.Equal(t, tc.wantedSCTE35, hasInband)
+			} else {
+				require.Equal(t, tc.wantedSCTE35, strings.Contains(string(body), scte35.SchemeIDURI))
+			}
+			if tc.wantedInBody != "" {
+				require.Contains(t, string(body), tc.wantedInBody)
+			}
+		})
+	}
+
+	_, err = parseSCTE35Schedules(map[string]string{"2": "period:900,dur:120"})
+	require.Error(t, err, "numeric schedule name")
+	_, err = parseSCTE35Schedules(map[string]string{"broadcast": "period:900"})
+	require.Error(t, err, "schedule without breaks")
+}
+
+func TestSCTE35MPDEventStream(t *testing.T) {
+	cfg := ServerConfig{
+		VodRoot:   "testdata/assets",
+		TimeoutS:  0,
+		LogFormat: logging.LogDiscard,
+	}
+	err := logging.InitSlog(cfg.LogLevel, cfg.LogFormat)
+	require.NoError(t, err)
+	server, err := SetupServer(context.Background(), &cfg)
+	require.NoError(t, err)
+	ts := httptest.NewServer(server.Router)
+	defer ts.Close()
+
+	// At 100s, the time-shift buffer starts at 40s, and the splice at 70s is in the MPD
+	// and announced in segment 31 [62s, 64s).
+	testCases := []struct {
+		desc         string
+		prefix       string
+		wantedCode   int
+		wantedIDs    []uint32
+		wantedTimesS []uint64
+		wantedInband bool
+	}{
+		{desc: "inband", prefix: "/livesim2/scte35_1/scte35sig_inband", wantedCode: http.StatusOK, wantedInband: true},
+		{desc: "mpd", prefix: "/livesim2/scte35_1/scte35sig_mpd", wantedCode: http.StatusOK, wantedIDs: []uint32{70},
+			wantedTimesS: []uint64{70}},
+		{desc: "both", prefix: "/livesim2/scte35_1/scte35sig_both", wantedCode: http.StatusOK, wantedIDs: []uint32{70},
+			wantedTimesS: []uint64{70}, wantedInband: true},
+		{desc: "time_signal", prefix: "/livesim2/scte35_1/scte35cmd_providerad/scte35sig_mpd", wantedCode: http.StatusOK,
+			wantedIDs: []uint32{140, 141}, wantedTimesS: []uint64{70, 90}},
+		{desc: "multi-period", prefix: "/livesim2/scte35_1/scte35sig_mpd/periods_60", wantedCode: http.StatusOK,
+			wantedIDs: []uint32{70}, wantedTimesS: []uint64{70}},
+		{desc: "unknown mode", prefix: "/livesim2/scte35_1/scte35sig_other", wantedCode: http.StatusBadRequest},
+		{desc: "without scte35", prefix: "/livesim2/scte35sig_mpd", wantedCode: http.StatusBadRequest},
+	}
+	binaryRe := regexp.MustCompile(`<Binary>([^<]+)</Binary>`)
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			resp, body := testFullRequest(t, ts, "GET", tc.prefix+"/testpic_2s/Manifest.mpd?nowMS=100000", nil)
+			require.Equal(t, tc.wantedCode, resp.StatusCode)
+			if tc.wantedCode != http.StatusOK {
+				return
+			}
+			require.Equal(t, tc.wantedInband, strings.Contains(string(body), scte35.SchemeIDURI))
+			mpd, err := m.ReadFromString(string(body))
+			require.NoError(t, err)
+			var ids []uint32
+			var timesS []uint64
+			for _, p := range mpd.Periods {
+				for _, es := range p.EventStreams {
+					require.Equal(t, scte35XMLBinSchemeIDURI, string(es.SchemeIdUri))
+					require.Equal(t, uint64(time.Duration(*p.Start).Seconds())*uint64(*es.Timescale), es.PresentationTimeOffset)
+					for _, e := range es.Events {
+						ids = append(ids, e.Id)
+						timesS = append(timesS, e.PresentationTime/uint64(*es.Timescale))
+					}
+				}
+			}
+			require.Equal(t, tc.wantedIDs, ids)
+			require.Equal(t, tc.wantedTimesS, timesS)
+			binaries := binaryRe.FindAllStringSubmatch(string(body), -1)
+			require.Len(t, binaries, len(tc.wantedIDs))
+
+			_, seg := testFullRequest(t, ts, "GET", tc.prefix+"/testpic_2s/V300/31.m4s?nowMS=100000", nil)
+			require.Equal(t, tc.wantedInband, strings.Contains(string(seg), scte35.SchemeIDURI))
+			if tc.wantedInband && len(binaries) > 0 {
+				payload, err := base64.StdEncoding.DecodeString(binaries[0][1])
+				require.NoError(t, err)
+				require.True(t, bytes.Contains(seg, payload), "same payload in MPD and emsg")
+			}
+		})
+	}
+}
@@ -452,6 +452,11 @@ func (a *asset) generateTimelineEntries(repID string, wt wrapTimes, atoMS int) s
 	se := segEntries{
 		mediaTimescale: uint32(rep.MediaTimescale),
 	}
+	if wt.nowMS < wt.startTimeMS { // before availabilityStartTime, so no segments
+		se.startNr = -1
+		se.lsi.nr = -1
+		return se
+	}
 
 	ato := uint64(atoMS * rep.MediaTimescale / 1000)
 
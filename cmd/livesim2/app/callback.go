@@ -0,0 +1,185 @@
+// Copyright 2023, DASH-Industry Forum. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.md file.
+
+package app
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Dash-Industry-Forum/livesim2/pkg/logging"
+	m "github.com/Eyevinn/dash-mpd/mpd"
+	"github.com/Eyevinn/mp4ff/mp4"
+)
+
+const (
+	callbackSchemeIDURI = "urn:mpeg:dash:event:callback:2015"
+	callbackValue       = "1"
+	// callbackMaxNrHits is the max number of callback hits kept. The oldest is dropped.
+	callbackMaxNrHits = 1000
+)
+
+// callbackURL returns the URL a player shall request for the callback event with id
+// scheduled at the wall-clock time atMS.
+func callbackURL(cfg *ResponseConfig, id uint32, atMS int64) string {
+	return fmt.Sprintf("%s/callback?id=%d&at=%d", cfg.Host, id, atMS)
+}
+
+// callbackEvent is a callback event at TimeMS milliseconds relative to availabilityStartTime.
+// The ID is the time in seconds.
+type callbackEvent struct {
+	TimeMS uint64
+	ID     uint32
+	URL    string
+}
+
+// callbackEvents returns the callback events in [startMS, endMS) relative to availabilityStartTime.
+func callbackEvents(cfg *ResponseConfig, startMS, endMS uint64) []callbackEvent {
+	intervalMS := uint64(*cfg.CallbackIntervalS) * 1000
+	var events []callbackEvent
+	for t := (startMS + intervalMS - 1) / intervalMS * intervalMS; t < endMS; t += intervalMS {
+		id := uint32(t / 1000)
+		events = append(events, callbackEvent{
+			TimeMS: t,
+			ID:     id,
+			URL:    callbackURL(cfg, id, int64(cfg.StartTimeS)*1000+int64(t)),
+		})
+	}
+	return events
+}
+
+// callbackEmsgs returns callback emsg boxes for the segment [segStart, segEnd) in timescale units.
+func callbackEmsgs(cfg *ResponseConfig, segStart, segEnd, timescale uint64) []*mp4.EmsgBox {
+	// Round up so that the events are in exactly one segment
+	startMS := (segStart*1000 + timescale - 1) / timescale
+	endMS := (segEnd*1000 + timescale - 1) / timescale
+	events := callbackEvents(cfg, startMS, endMS)
+	emsgs := make([]*mp4.EmsgBox, 0, len(events))
+	for _, ev := range events {
+		emsgs = append(emsgs, &mp4.EmsgBox{
+			Version:          1,
+			TimeScale:        uint32(timescale),
+			PresentationTime: ev.TimeMS * timescale / 1000,
+			ID:               ev.ID,
+			SchemeIDURI:      callbackSchemeIDURI,
+			Value:            callbackValue,
+			MessageData:      []byte(ev.URL),
+		})
+	}
+	return emsgs
+}
+
+// addCallbackEventStreams adds EventStreams with the callback events in the time-shift buffer
+// and the next callback interval to all Periods of the MPD.
+// Every event is put in the Period where its time is.
+func addCallbackEventStreams(mpd *m.MPD, cfg *ResponseConfig, nowMS int) {
+	windowStartMS, windowEndMS := mpdEventWindowMS(cfg, nowMS, *cfg.CallbackIntervalS*1000)
+	events := callbackEvents(cfg, uint64(windowStartMS), uint64(windowEndMS))
+	for i, p := range mpd.Periods {
+		if p.XlinkHref != "" {
+			continue // Remote period without content
+		}
+		periodStartMS, periodEndMS := periodItvlMS(mpd.Periods, i)
+		es := &m.EventStreamType{
+			SchemeIdUri:            callbackSchemeIDURI,
+			Value:                  callbackValue,
+			Timescale:              Ptr(uint32(1000)),
+			PresentationTimeOffset: periodStartMS,
+		}
+		for _, ev := range events {
+			if periodStartMS <= ev.TimeMS && ev.TimeMS < periodEndMS {
+				es.Events = append(es.Events, &m.EventType{
+					PresentationTime: ev.TimeMS,
+					Id:               ev.ID,
+					MessageData:      ev.URL,
+				})
+			}
+		}
+		if len(es.Events) > 0 {
+			p.EventStreams = append(p.EventStreams, es)
+		}
+	}
+}
+
+// callbackHit is a received callback request.
+type callbackHit struct {
+	EventID     uint32    `json:"eventID"`
+	ScheduledAt time.Time `json:"scheduledAt"`
+	ReceivedAt  time.Time `json:"receivedAt"`
+	// DelayMS is the time between the scheduled event time and the callback request.
+	DelayMS   int64  `json:"delayMS"`
+	Client    string `json:"client"`
+	UserAgent string `json:"userAgent,omitempty"`
+}
+
+// callbackStore keeps the most recent callback hits.
+type callbackStore struct {
+	mu    sync.Mutex
+	maxNr int
+	hits  []callbackHit
+}
+
+func newCallbackStore(maxNr int) *callbackStore {
+	return &callbackStore{maxNr: maxNr}
+}
+
+// add stores a hit. The oldest hit is dropped if the store is full.
+func (cs *callbackStore) add(hit callbackHit) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if len(cs.hits) >= cs.maxNr {
+		cs.hits = cs.hits[1:]
+	}
+	cs.hits = append(cs.hits, hit)
+}
+
+// list returns a copy of the stored hits in order of arrival.
+func (cs *callbackStore) list() []callbackHit {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	hits := make([]callbackHit, len(cs.hits))
+	copy(hits, cs.hits)
+	return hits
+}
+
+// callbackHandlerFunc records a callback request from a player.
+// The query parameters id and at are the event ID and the scheduled time in milliseconds.
+func (s *Server) callbackHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	q := r.URL.Query()
+	id, err := strconv.ParseUint(q.Get("id"), 10, 32)
+	if err != nil {
+		http.Error(w, "bad callback id", http.StatusBadRequest)
+		return
+	}
+	atMS, err := strconv.ParseInt(q.Get("at"), 10, 64)
+	if err != nil {
+		http.Error(w, "bad callback time", http.StatusBadRequest)
+		return
+	}
+	hit := callbackHit{
+		EventID:     uint32(id),
+		ScheduledAt: time.UnixMilli(atMS).UTC(),
+		ReceivedAt:  now.UTC(),
+		DelayMS:     now.UnixMilli() - atMS,
+		Client:      r.RemoteAddr,
+		UserAgent:   r.UserAgent(),
+	}
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		hit.Client = fwd
+	}
+	s.callbacks.add(hit)
+	slog.Info("callback", "request_id", logging.GetRequestID(r), "id", hit.EventID,
+		"client", hit.Client, "delayMS", hit.DelayMS)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// callbacksHandlerFunc lists the received callback requests as JSON.
+func (s *Server) callbacksHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	s.jsonResponse(w, s.callbacks.list(), http.StatusOK)
+}
@@ -0,0 +1,131 @@
+// Copyright 2023, DASH-Industry Forum. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.md file.
+
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Dash-Industry-Forum/livesim2/pkg/logging"
+	m "github.com/Eyevinn/dash-mpd/mpd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCallbackEmsgs(t *testing.T) {
+	cfg := &ResponseConfig{StartTimeS: 100, CallbackIntervalS: Ptr(5), Host: "http://localhost"}
+	timescale := uint64(90_000)
+	testCases := []struct {
+		desc      string
+		segStart  uint64
+		wantedIDs []uint32
+	}{
+		{desc: "event at start", segStart: 10, wantedIDs: []uint32{10}},
+		{desc: "no event", segStart: 12},
+		{desc: "event inside", segStart: 4, wantedIDs: []uint32{5}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			emsgs := callbackEmsgs(cfg, tc.segStart*timescale, (tc.segStart+2)*timescale, timescale)
+			require.Len(t, emsgs, len(tc.wantedIDs))
+			for i, e := range emsgs {
+				require.Equal(t, tc.wantedIDs[i], e.ID)
+				require.Equal(t, uint64(e.ID)*timescale, e.PresentationTime)
+				require.Equal(t, callbackSchemeIDURI, e.SchemeIDURI)
+				require.Equal(t, callbackURL(cfg, e.ID, int64(100+e.ID)*1000), string(e.MessageData))
+			}
+		})
+	}
+	require.Equal(t, "http://localhost/callback?id=5&at=105000", callbackURL(cfg, 5, 105_000))
+}
+
+func TestCallbackEvents(t *testing.T) {
+	cfg := ServerConfig{
+		VodRoot:   "testdata/assets",
+		TimeoutS:  0,
+		LogFormat: logging.LogDiscard,
+	}
+	err := logging.InitSlog(cfg.LogLevel, cfg.LogFormat)
+	require.NoError(t, err)
+	server, err := SetupServer(context.Background(), &cfg)
+	require.NoError(t, err)
+	ts := httptest.NewServer(server.Router)
+	defer ts.Close()
+
+	// At 100s, the MPD events cover the time-shift buffer from 40s and the next interval.
+	testCases := []struct {
+		desc         string
+		prefix       string
+		wantedCode   int
+		wantedMPDIDs []uint32
+		wantedInband bool
+	}{
+		{desc: "inband", prefix: "/livesim2/callback_10", wantedCode: http.StatusOK, wantedInband: true},
+		{desc: "mpd", prefix: "/livesim2/callback_10/callbacksig_mpd", wantedCode: http.StatusOK,
+			wantedMPDIDs: []uint32{40, 50, 60, 70, 80, 90, 100}},
+		{desc: "both", prefix: "/livesim2/callback_10/callbacksig_both", wantedCode: http.StatusOK,
+			wantedMPDIDs: []uint32{40, 50, 60, 70, 80, 90, 100}, wantedInband: true},
+		{desc: "multi-period", prefix: "/livesim2/callback_10/callbacksig_mpd/periods_60", wantedCode: http.StatusOK,
+			wantedMPDIDs: []uint32{40, 50, 60, 70, 80, 90, 100}},
+		{desc: "bad interval", prefix: "/livesim2/callback_0", wantedCode: http.StatusBadRequest},
+		{desc: "unknown mode", prefix: "/livesim2/callback_10/callbacksig_other", wantedCode: http.StatusBadRequest},
+		{desc: "mode without callback", prefix: "/livesim2/callbacksig_mpd", wantedCode: http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			resp, body := testFullRequest(t, ts, "GET", tc.prefix+"/testpic_2s/Manifest.mpd?nowMS=100000", nil)
+			require.Equal(t, tc.wantedCode, resp.StatusCode)
+			if tc.wantedCode != http.StatusOK {
+				return
+			}
+			mpd, err := m.ReadFromString(string(body))
+			require.NoError(t, err)
+			var ids []uint32
+			for _, p := range mpd.Periods {
+				for _, es := range p.EventStreams {
+					require.Equal(t, callbackSchemeIDURI, string(es.SchemeIdUri))
+					require.Equal(t, uint64(p.Start.Seconds()*1000), es.PresentationTimeOffset)
+					for _, e := range es.Events {
+						require.Equal(t, uint64(e.Id)*1000, e.PresentationTime)
+						require.True(t, strings.HasSuffix(e.MessageData, fmt.Sprintf("/callback?id=%d&at=%d", e.Id, e.Id*1000)))
+						ids = append(ids, e.Id)
+					}
+				}
+			}
+			require.Equal(t, tc.wantedMPDIDs, ids)
+			require.Equal(t, tc.wantedInband, strings.Contains(string(body), "<InbandEventStream schemeIdUri=\""+callbackSchemeIDURI))
+
+			_, seg := testFullRequest(t, ts, "GET", tc.prefix+"/testpic_2s/V300/20.m4s?nowMS=100000", nil)
+			require.Equal(t, tc.wantedInband, strings.Contains(string(seg), "/callback?id=40&at=40000"))
+		})
+	}
+
+	resp, _ := testFullRequest(t, ts, "GET", "/callback?id=40&at=40000", nil)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp, _ = testFullRequest(t, ts, "GET", "/callback?id=x&at=40000", nil)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, body := testFullRequest(t, ts, "GET", "/callbacks", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var hits []callbackHit
+	require.NoError(t, json.Unmarshal(body, &hits))
+	require.Len(t, hits, 1)
+	require.Equal(t, uint32(40), hits[0].EventID)
+	require.Equal(t, int64(40000), hits[0].ScheduledAt.UnixMilli())
+}
+
+func TestCallbackStore(t *testing.T) {
+	cs := newCallbackStore(2)
+	for id := uint32(1); id <= 3; id++ {
+		cs.add(callbackHit{EventID: id})
+	}
+	hits := cs.list()
+	require.Len(t, hits, 2)
+	require.Equal(t, uint32(2), hits[0].EventID)
+	require.Equal(t, uint32(3), hits[1].EventID)
+}
@@ -97,7 +97,7 @@ func (cm *cmafIngesterMgr) NewCmafIngester(req CmafIngesterSetup) (nr uint64, er
 	if req.TestNowMS != nil {
 		mpdReq.URL.RawQuery = fmt.Sprintf("nowMS=%d", *req.TestNowMS)
 	}
-	nowMS, cfg, errHT := cfgFromRequest(mpdReq, log)
+	nowMS, cfg, errHT := cm.s.cfgFromRequest(mpdReq, log)
 	if errHT != nil {
 		return 0, fmt.Errorf("failed to get config from request: %w", errHT)
 	}
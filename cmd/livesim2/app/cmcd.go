@@ -0,0 +1,310 @@
+// Copyright 2023, DASH-Industry Forum. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.md file.
+
+package app
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dash-Industry-Forum/livesim2/pkg/logging"
+)
+
+const (
+	cmcdQueryParam = "CMCD"
+	// cmcdMaxNrSessions is the max number of sessions kept. The least recently seen is dropped.
+	cmcdMaxNrSessions = 1000
+)
+
+// cmcdHeaders are the header names defined in CTA-5004 for header transmission.
+var cmcdHeaders = []string{"CMCD-Request", "CMCD-Object", "CMCD-Status", "CMCD-Session"}
+
+// CMCD is Common Media Client Data (CTA-5004) sent by a player.
+// Values are int for integer keys, bool for boolean keys, and string for strings and tokens.
+type CMCD map[string]any
+
+// CMCDFromRequest extracts CMCD data from query parameter or headers.
+// The result is nil if no CMCD data is present.
+func CMCDFromRequest(r *http.Request) (CMCD, error) {
+	var c CMCD
+	payloads := make([]string, 0, len(cmcdHeaders)+1)
+	if q := r.URL.Query().Get(cmcdQueryParam); q != "" {
+		payloads = append(payloads, q)
+	}
+	for _, hdr := range cmcdHeaders {
+		if h := r.Header.Get(hdr); h != "" {
+			payloads = append(payloads, h)
+		}
+	}
+	for _, p := range payloads {
+		if c == nil {
+			c = make(CMCD)
+		}
+		if err := c.parse(p); err != nil {
+			return nil, fmt.Errorf("cmcd: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// parse parses a CMCD payload of comma-separated key[=value] pairs into c.
+func (c CMCD) parse(payload string) error {
+	i := 0
+	for i < len(payload) {
+		end := i
+		for end < len(payload) && payload[end] != '=' && payload[end] != ',' {
+			end++
+		}
+		key := strings.TrimSpace(payload[i:end])
+		if !validCMCDKey(key) {
+			return fmt.Errorf("bad key %q", key)
+		}
+		if end == len(payload) || payload[end] == ',' {
+			c[key] = true
+			i = end + 1
+			continue
+		}
+		i = end + 1 // Skip '='
+		if i < len(payload) && payload[i] == '"' {
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(payload) && payload[j] != '"'; j++ {
+				if payload[j] == '\\' && j+1 < len(payload) {
+					j++
+				}
+				sb.WriteByte(payload[j])
+			}
+			if j == len(payload) {
+				return fmt.Errorf("unterminated string for key %q", key)
+			}
+			c[key] = sb.String()
+			i = j + 1
+			if i < len(payload) && payload[i] != ',' {
+				return fmt.Errorf("bad value for key %q", key)
+			}
+			i++
+			continue
+		}
+		end = strings.IndexByte(payload[i:], ',')
+		if end < 0 {
+			end = len(payload)
+		} else {
+			end += i
+		}
+		val := strings.TrimSpace(payload[i:end])
+		switch {
+		case val == "true":
+			c[key] = true
+		case val == "false":
+			c[key] = false
+		default:
+			if n, err := strconv.Atoi(val); err == nil {
+				c[key] = n
+			} else {
+				c[key] = val
+			}
+		}
+		i = end + 1
+	}
+	return nil
+}
+
+// validCMCDKey checks the key syntax. Reserved keys are lower case, while custom keys
+// have a hyphenated prefix like com.example-myKey and may also contain upper case and dots.
+func validCMCDKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	isCustom := strings.Contains(key, "-")
+	for _, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+		case isCustom && (r >= 'A' && r <= 'Z' || r == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// IntVal returns the integer value of key, if present.
+func (c CMCD) IntVal(key string) (int, bool) {
+	n, ok := c[key].(int)
+	return n, ok
+}
+
+// StringVal returns the string value of key, if present.
+func (c CMCD) StringVal(key string) string {
+	s, _ := c[key].(string)
+	return s
+}
+
+// BoolVal returns true if the boolean key is present and true.
+func (c CMCD) BoolVal(key string) bool {
+	b, _ := c[key].(bool)
+	return b
+}
+
+// logAttrs returns the CMCD data as sorted slog attributes.
+func (c CMCD) logAttrs() []any {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	attrs := make([]any, 0, len(keys))
+	for _, k := range keys {
+		attrs = append(attrs, slog.Any(k, c[k]))
+	}
+	return attrs
+}
+
+// cmcdSession aggregates the CMCD data reported for one session ID.
+type cmcdSession struct {
+	SessionID                  string         `json:"sid"`
+	ContentID                  string         `json:"cid,omitempty"`
+	StreamType                 string         `json:"st,omitempty"`
+	FirstSeen                  time.Time      `json:"firstSeen"`
+	LastSeen                   time.Time      `json:"lastSeen"`
+	NrRequests                 int            `json:"nrRequests"`
+	ObjectTypes                map[string]int `json:"objectTypes"`
+	NrStartups                 int            `json:"nrStartups"`
+	NrStarvations              int            `json:"nrStarvations"`
+	BufferLengthMS             int            `json:"bufferLengthMS"`
+	MinBufferLengthMS          int            `json:"minBufferLengthMS"`
+	BitrateKbps                int            `json:"bitrateKbps"`
+	MeasuredThroughputKbps     int            `json:"measuredThroughputKbps"`
+	RequestedMaxThroughputKbps int            `json:"requestedMaxThroughputKbps"`
+	Last                       CMCD           `json:"last"`
+}
+
+// cmcdStore keeps per-session CMCD aggregates.
+type cmcdStore struct {
+	mu            sync.Mutex
+	maxNrSessions int
+	sessions      map[string]*cmcdSession
+}
+
+func newCMCDStore(maxNrSessions int) *cmcdStore {
+	return &cmcdStore{
+		maxNrSessions: maxNrSessions,
+		sessions:      make(map[string]*cmcdSession),
+	}
+}
+
+// add aggregates c into its session. Data without session ID is not aggregated.
+func (cs *cmcdStore) add(c CMCD, now time.Time) {
+	sid := c.StringVal("sid")
+	if sid == "" {
+		return
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	s, ok := cs.sessions[sid]
+	if !ok {
+		if len(cs.sessions) >= cs.maxNrSessions {
+			cs.dropOldest()
+		}
+		s = &cmcdSession{
+			SessionID:         sid,
+			FirstSeen:         now,
+			ObjectTypes:       make(map[string]int),
+			MinBufferLengthMS: -1,
+		}
+		cs.sessions[sid] = s
+	}
+	s.LastSeen = now
+	s.NrRequests++
+	s.Last = c
+	if cid := c.StringVal("cid"); cid != "" {
+		s.ContentID = cid
+	}
+	if st := c.StringVal("st"); st != "" {
+		s.StreamType = st
+	}
+	if ot := c.StringVal("ot"); ot != "" {
+		s.ObjectTypes[ot]++
+	}
+	if c.BoolVal("su") {
+		s.NrStartups++
+	}
+	if c.BoolVal("bs") {
+		s.NrStarvations++
+	}
+	if bl, ok := c.IntVal("bl"); ok {
+		s.BufferLengthMS = bl
+		if s.MinBufferLengthMS < 0 || bl < s.MinBufferLengthMS {
+			s.MinBufferLengthMS = bl
+		}
+	}
+	if br, ok := c.IntVal("br"); ok {
+		s.BitrateKbps = br
+	}
+	if mtp, ok := c.IntVal("mtp"); ok {
+		s.MeasuredThroughputKbps = mtp
+	}
+	if rtp, ok := c.IntVal("rtp"); ok {
+		s.RequestedMaxThroughputKbps = rtp
+	}
+	cmcdMetrics.observe(c, len(cs.sessions))
+}
+
+// dropOldest removes the least recently seen session. Must be called with lock held.
+func (cs *cmcdStore) dropOldest() {
+	var oldest *cmcdSession
+	for _, s := range cs.sessions {
+		if oldest == nil || s.LastSeen.Before(oldest.LastSeen) {
+			oldest = s
+		}
+	}
+	if oldest != nil {
+		delete(cs.sessions, oldest.SessionID)
+	}
+}
+
+// list returns copies of all sessions sorted by most recently seen first.
+func (cs *cmcdStore) list() []cmcdSession {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	sessions := make([]cmcdSession, 0, len(cs.sessions))
+	for _, s := range cs.sessions {
+		sc := *s
+		sc.ObjectTypes = maps.Clone(s.ObjectTypes)
+		sessions = append(sessions, sc)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].LastSeen.Equal(sessions[j].LastSeen) {
+			return sessions[i].SessionID < sessions[j].SessionID
+		}
+		return sessions[i].LastSeen.After(sessions[j].LastSeen)
+	})
+	return sessions
+}
+
+// newCMCDMiddleware returns a middleware that logs CMCD data and aggregates it in cs.
+// Bad CMCD data is logged, but does not change the response.
+func newCMCDMiddleware(cs *cmcdStore) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			c, err := CMCDFromRequest(r)
+			switch {
+			case err != nil:
+				slog.Warn("bad CMCD data", "request_id", logging.GetRequestID(r), "url", r.URL.Path, "err", err)
+			case c != nil:
+				slog.Info("cmcd", "request_id", logging.GetRequestID(r), "url", r.URL.Path,
+					slog.Group("cmcd", c.logAttrs()...))
+				cs.add(c, time.Now())
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
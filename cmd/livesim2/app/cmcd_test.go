@@ -0,0 +1,156 @@
+// Copyright 2023, DASH-Industry Forum. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.md file.
+
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Dash-Industry-Forum/livesim2/pkg/logging"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCMCDFromRequest(t *testing.T) {
+	cases := []struct {
+		desc      string
+		query     string
+		headers   map[string]string
+		wanted    CMCD
+		wantedErr bool
+	}{
+		{
+			desc:   "no cmcd",
+			query:  "nowMS=10000",
+			wanted: nil,
+		},
+		{
+			desc:  "query",
+			query: "CMCD=" + url.QueryEscape(`bl=21300,br=3200,bs,cid="faec5fc2-ac30-11eabb37-0242ac130002",mtp=25400,ot=v,rtp=15000,sid="6e2fb550-c457-11e9-bb97-0800200c9a66",su,tb=6000`),
+			wanted: CMCD{"bl": 21300, "br": 3200, "bs": true, "cid": "faec5fc2-ac30-11eabb37-0242ac130002",
+				"mtp": 25400, "ot": "v", "rtp": 15000, "sid": "6e2fb550-c457-11e9-bb97-0800200c9a66", "su": true, "tb": 6000},
+		},
+		{
+			desc: "headers",
+			headers: map[string]string{
+				"CMCD-Request": "bl=1000,mtp=5000",
+				"CMCD-Object":  "br=300,d=2000,ot=v",
+				"CMCD-Session": `sid="s1",st=l,sf=d`,
+			},
+			wanted: CMCD{"bl": 1000, "mtp": 5000, "br": 300, "d": 2000, "ot": "v", "sid": "s1", "st": "l", "sf": "d"},
+		},
+		{
+			desc:   "escaped string and explicit false",
+			query:  "CMCD=" + url.QueryEscape(`sid="a\"b,c",bs=false`),
+			wanted: CMCD{"sid": `a"b,c`, "bs": false},
+		},
+		{
+			desc:      "unterminated string",
+			query:     "CMCD=" + url.QueryEscape(`sid="abc`),
+			wantedErr: true,
+		},
+		{
+			desc:  "custom keys",
+			query: "CMCD=" + url.QueryEscape(`br=300,com.example-myKey="abc",com.example-myFlag,com.example-level=3`),
+			headers: map[string]string{
+				"CMCD-Session": `sid="s1",com.example-Session="x"`,
+			},
+			wanted: CMCD{"br": 300, "com.example-myKey": "abc", "com.example-myFlag": true, "com.example-level": 3,
+				"sid": "s1", "com.example-Session": "x"},
+		},
+		{
+			desc:      "bad key",
+			query:     "CMCD=" + url.QueryEscape(`BL=100`),
+			wantedErr: true,
+		},
+		{
+			desc:      "upper case without prefix",
+			query:     "CMCD=" + url.QueryEscape(`br=300,com.example.MyKey=1`),
+			wantedErr: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/livesim2/testpic_2s/V300/10.m4s?"+tc.query, nil)
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+			got, err := CMCDFromRequest(r)
+			if tc.wantedErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.wanted, got)
+		})
+	}
+}
+
+func TestCMCDStore(t *testing.T) {
+	cs := newCMCDStore(2)
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cs.add(CMCD{"sid": "s1", "su": true, "ot": "m", "bl": 0}, t0)
+	cs.add(CMCD{"sid": "s1", "ot": "v", "bl": 4000, "br": 300, "mtp": 8000}, t0.Add(time.Second))
+	cs.add(CMCD{"sid": "s1", "ot": "v", "bl": 2000, "bs": true}, t0.Add(2*time.Second))
+	cs.add(CMCD{"ot": "v"}, t0.Add(2*time.Second))
+	sessions := cs.list()
+	require.Len(t, sessions, 1)
+	s := sessions[0]
+	require.Equal(t, 3, s.NrRequests)
+	require.Equal(t, 1, s.NrStartups)
+	require.Equal(t, 1, s.NrStarvations)
+	require.Equal(t, map[string]int{"m": 1, "v": 2}, s.ObjectTypes)
+	require.Equal(t, 2000, s.BufferLengthMS)
+	require.Equal(t, 0, s.MinBufferLengthMS)
+	require.Equal(t, 300, s.BitrateKbps)
+	require.Equal(t, 8000, s.MeasuredThroughputKbps)
+	require.Equal(t, t0, s.FirstSeen)
+
+	cs.add(CMCD{"sid": "s2"}, t0.Add(3*time.Second))
+	cs.add(CMCD{"sid": "s3"}, t0.Add(4*time.Second))
+	sessions = cs.list()
+	require.Len(t, sessions, 2)
+	require.Equal(t, "s3", sessions[0].SessionID)
+	require.Equal(t, "s2", sessions[1].SessionID)
+}
+
+func TestCMCDEndpoint(t *testing.T) {
+	cfg := ServerConfig{
+		VodRoot:   "testdata/assets",
+		TimeoutS:  0,
+		LogFormat: logging.LogDiscard,
+	}
+	err := logging.InitSlog(cfg.LogLevel, cfg.LogFormat)
+	require.NoError(t, err)
+	server, err := SetupServer(context.Background(), &cfg)
+	require.NoError(t, err)
+	ts := httptest.NewServer(server.Router)
+	defer ts.Close()
+
+	cmcd := url.QueryEscape(`bl=4000,br=300,com.example-player="v1",ot=v,sid="player1"`)
+	resp, _ := testFullRequest(t, ts, "GET", "/livesim2/testpic_2s/V300/45.m4s?nowMS=100000&CMCD="+cmcd, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = testFullRequest(t, ts, "GET", "/livesim2/testpic_2s/Manifest.mpd?nowMS=100000&CMCD="+url.QueryEscape("bad key"), nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, "bad CMCD data should not fail request")
+
+	resp, body := testFullRequest(t, ts, "GET", "/cmcd?format=json", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var sessions []cmcdSession
+	err = json.Unmarshal(body, &sessions)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	require.Equal(t, "player1", sessions[0].SessionID)
+	require.Equal(t, 4000, sessions[0].BufferLengthMS)
+	require.Equal(t, 300, sessions[0].BitrateKbps)
+	require.Equal(t, "v1", sessions[0].Last["com.example-player"])
+
+	resp, body = testFullRequest(t, ts, "GET", "/cmcd", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Contains(t, string(body), "<td>player1</td>")
+}
@@ -0,0 +1,139 @@
+// Copyright 2023, DASH-Industry Forum. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.md file.
+
+package app
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+)
+
+const (
+	cmsdStaticHeader  = "CMSD-Static"
+	cmsdDynamicHeader = "CMSD-Dynamic"
+	defaultCMSDOrigin = "livesim2"
+)
+
+// cmsdStatic is the content of a CMSD-Static (CTA-5006) header.
+// Zero values are not sent.
+type cmsdStatic struct {
+	AvailMS    int64  // at: availability time (ms since epoch)
+	DurMS      int    // d: object duration (ms)
+	Origin     string // n: origin name
+	ObjectType string // ot: object type
+	StreamType string // st: stream type
+}
+
+// String returns the header value with keys in alphabetical order.
+func (cs cmsdStatic) String() string {
+	parts := make([]string, 0, 5)
+	if cs.AvailMS > 0 {
+		parts = append(parts, fmt.Sprintf("at=%d", cs.AvailMS))
+	}
+	if cs.DurMS > 0 {
+		parts = append(parts, fmt.Sprintf("d=%d", cs.DurMS))
+	}
+	if cs.Origin != "" {
+		parts = append(parts, fmt.Sprintf("n=%q", cs.Origin))
+	}
+	if cs.ObjectType != "" {
+		parts = append(parts, "ot="+cs.ObjectType)
+	}
+	if cs.StreamType != "" {
+		parts = append(parts, "st="+cs.StreamType)
+	}
+	return strings.Join(parts, ",")
+}
+
+// cmsdObjectType returns the CMSD object type token for a representation content type.
+func cmsdObjectType(contentType string) string {
+	switch contentType {
+	case "video":
+		return "v"
+	case "audio":
+		return "a"
+	case "text":
+		return "c"
+	default:
+		return "o"
+	}
+}
+
+// cmsdOrigin returns the origin name to use in CMSD-Static headers.
+func cmsdOrigin(serverCfg *ServerConfig) string {
+	if serverCfg.CMSDOrigin != "" {
+		return serverCfg.CMSDOrigin
+	}
+	return defaultCMSDOrigin
+}
+
+// setCMSDManifestHeaders sets CMSD headers for an MPD or HLS playlist response.
+func setCMSDManifestHeaders(w http.ResponseWriter, cfg *ResponseConfig, origin string, nowMS int) {
+	cs := cmsdStatic{Origin: origin, ObjectType: "m", StreamType: "l"}
+	w.Header().Set(cmsdStaticHeader, cs.String())
+	setCMSDDynamicHeader(w, cfg, origin, nowMS)
+}
+
+// setCMSDSegmentHeaders sets CMSD headers for an init or media segment response.
+// Availability time and duration are added by setCMSDSegmentTiming when a media segment has been generated.
+func setCMSDSegmentHeaders(w http.ResponseWriter, cfg *ResponseConfig, a *asset, segmentPart, origin string, nowMS int) {
+	cs := cmsdStatic{Origin: origin, StreamType: "l"}
+	for _, rep := range a.Reps {
+		if segmentPart == rep.InitURI {
+			cs.ObjectType = "i"
+			break
+		}
+	}
+	if cs.ObjectType == "" {
+		rep, _, err := findRepAndSegmentID(a, segmentPart)
+		if err != nil {
+			cs.ObjectType = "o"
+		} else {
+			cs.ObjectType = cmsdObjectType(rep.ContentType)
+		}
+	}
+	w.Header().Set(cmsdStaticHeader, cs.String())
+	setCMSDDynamicHeader(w, cfg, origin, nowMS)
+}
+
+// setCMSDSegmentTiming adds availability time and duration of a generated segment to the
+// CMSD-Static header, if set. It must be called after any chunkdur-derived availabilityTimeOffset is applied.
+func setCMSDSegmentTiming(w http.ResponseWriter, cfg *ResponseConfig, sm segMeta) {
+	static := w.Header().Get(cmsdStaticHeader)
+	if static == "" {
+		return
+	}
+	var cs cmsdStatic
+	cs.AvailMS, cs.DurMS = cmsdSegmentTiming(cfg, sm)
+	// at and d are the first keys in alphabetical order
+	w.Header().Set(cmsdStaticHeader, cs.String()+","+static)
+}
+
+// cmsdSegmentTiming returns availability time and duration in ms for a segment.
+func cmsdSegmentTiming(cfg *ResponseConfig, sm segMeta) (availMS int64, durMS int) {
+	ts := int64(sm.timescale)
+	durMS = int(int64(sm.newDur) * 1000 / ts)
+	availMS = int64(cfg.StartTimeS) * 1000
+	ato := cfg.getAvailabilityTimeOffsetS()
+	if ato == math.Inf(1) {
+		return availMS, durMS
+	}
+	availMS += int64(sm.newTime+uint64(sm.newDur))*1000/ts - int64(ato*1000)
+	return availMS, durMS
+}
+
+// setCMSDDynamicHeader sets the CMSD-Dynamic header if a CMSD schedule is configured.
+func setCMSDDynamicHeader(w http.ResponseWriter, cfg *ResponseConfig, origin string, nowMS int) {
+	if len(cfg.CMSD) == 0 {
+		return
+	}
+	itvl := cfg.cmsdAt(nowMS / 1000)
+	val := fmt.Sprintf("%q;etp=%d", origin, itvl.EtpKbps)
+	if itvl.RttMS > 0 {
+		val += fmt.Sprintf(";rtt=%d", itvl.RttMS)
+	}
+	w.Header().Set(cmsdDynamicHeader, val)
+}
@@ -0,0 +1,113 @@
+// Copyright 2023, DASH-Industry Forum. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.md file.
+
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Dash-Industry-Forum/livesim2/pkg/logging"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateCMSDScript(t *testing.T) {
+	cases := []struct {
+		pattern   string
+		wanted    []CMSDItvl
+		wantedErr bool
+	}{
+		{pattern: "5000", wanted: []CMSDItvl{{DurS: 1, EtpKbps: 5000}}},
+		{pattern: "5000:20", wanted: []CMSDItvl{{DurS: 1, EtpKbps: 5000, RttMS: 20}}},
+		{pattern: "5000:20@30,1000@30", wanted: []CMSDItvl{{DurS: 30, EtpKbps: 5000, RttMS: 20}, {DurS: 30, EtpKbps: 1000}}},
+		{pattern: "5000,1000@30", wantedErr: true},
+		{pattern: "5000@0", wantedErr: true},
+		{pattern: "0@10", wantedErr: true},
+		{pattern: "5000:x@10", wantedErr: true},
+	}
+	for _, tc := range cases {
+		got, err := CreateCMSDScript(tc.pattern)
+		if tc.wantedErr {
+			require.Error(t, err, tc.pattern)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, tc.wanted, got)
+	}
+}
+
+func TestCMSDHeaders(t *testing.T) {
+	cfg := ServerConfig{
+		VodRoot:    "testdata/assets",
+		TimeoutS:   0,
+		LogFormat:  logging.LogDiscard,
+		CMSDOrigin: "origin-1",
+	}
+	err := logging.InitSlog(cfg.LogLevel, cfg.LogFormat)
+	require.NoError(t, err)
+	server, err := SetupServer(context.Background(), &cfg)
+	require.NoError(t, err)
+	ts := httptest.NewServer(server.Router)
+	defer ts.Close()
+
+	testCases := []struct {
+		desc          string
+		url           string
+		wantedStatic  string
+		wantedDynamic string
+	}{
+		{
+			desc:         "mpd",
+			url:          "/livesim2/testpic_2s/Manifest.mpd?nowMS=100000",
+			wantedStatic: `n="origin-1",ot=m,st=l`,
+		},
+		{
+			desc:         "hls playlist",
+			url:          "/livesim2/testpic_2s/Manifest_V300.m3u8?nowMS=100000",
+			wantedStatic: `n="origin-1",ot=m,st=l`,
+		},
+		{
+			desc:         "init segment",
+			url:          "/livesim2/testpic_2s/V300/init.mp4?nowMS=100000",
+			wantedStatic: `n="origin-1",ot=i,st=l`,
+		},
+		{
+			desc:         "video segment",
+			url:          "/livesim2/testpic_2s/V300/45.m4s?nowMS=100000",
+			wantedStatic: `at=92000,d=2000,n="origin-1",ot=v,st=l`,
+		},
+		{
+			desc:         "audio segment",
+			url:          "/livesim2/testpic_2s/A48/45.m4s?nowMS=100000",
+			wantedStatic: `at=92010,d=2005,n="origin-1",ot=a,st=l`, // Audio segment timing, as in the MPD
+		},
+		{
+			desc:         "low-latency segment",
+			url:          "/livesim2/chunkdur_0.5/testpic_2s/V300/45.m4s?nowMS=100000",
+			wantedStatic: `at=90500,d=2000,n="origin-1",ot=v,st=l`,
+		},
+		{
+			desc:          "dynamic, second interval",
+			url:           "/livesim2/cmsd_5000:20@30,1000@30/testpic_2s/V300/45.m4s?nowMS=100000",
+			wantedStatic:  `at=92000,d=2000,n="origin-1",ot=v,st=l`,
+			wantedDynamic: `"origin-1";etp=1000`,
+		},
+		{
+			desc:          "dynamic, first interval",
+			url:           "/livesim2/cmsd_5000:20@30,1000@30/testpic_2s/Manifest.mpd?nowMS=70000",
+			wantedStatic:  `n="origin-1",ot=m,st=l`,
+			wantedDynamic: `"origin-1";etp=5000;rtt=20`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			resp, _ := testFullRequest(t, ts, "GET", tc.url, nil)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			require.Equal(t, tc.wantedStatic, resp.Header.Get("CMSD-Static"))
+			require.Equal(t, tc.wantedDynamic, resp.Header.Get("CMSD-Dynamic"))
+		})
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 
 	"github.com/knadh/koanf"
@@ -20,6 +21,7 @@ import (
 
 	"github.com/Dash-Industry-Forum/livesim2/pkg/drm"
 	"github.com/Dash-Industry-Forum/livesim2/pkg/logging"
+	"github.com/Dash-Industry-Forum/livesim2/pkg/scte35"
 	"github.com/spf13/pflag"
 )
 
@@ -64,6 +66,18 @@ type ServerConfig struct {
 	PlayURL    string         `json:"playurl"`
 	DrmCfgFile string         `json:"drmcfgfile"`
 	DrmCfg     *drm.DrmConfig `json:"drmcfg"`
+	// AdAsset is the path to the MPD of the asset used for ad periods with insertad_1.
+	// If empty, the live asset itself is used.
+	AdAsset string `json:"adasset"`
+	// CMSDOrigin is the origin name sent as n in CMSD-Static headers.
+	// If empty, "livesim2" is used.
+	CMSDOrigin string `json:"cmsdorigin"`
+	// SCTE35Schedules maps names to SCTE-35 schedules like "period:900,offset:60,dur:120".
+	// A schedule is selected by scte35_<name> in the URL. Only settable in the config file.
+	SCTE35Schedules map[string]string `json:"scte35schedules"`
+	// ThrottleTraces maps names to bandwidth trace files with "<time_s> <kbps>" lines.
+	// A trace is selected by throttle_<name> in the URL. Only settable in the config file.
+	ThrottleTraces map[string]string `json:"throttletraces"`
 }
 
 var DefaultConfig = ServerConfig{
@@ -130,6 +144,8 @@ func LoadConfig(args []string, cwd string) (*ServerConfig, error) {
 	f.String("host", k.String("host"), "host (and possible prefix) used in MPD elements. Overrides auto-detected full scheme://host")
 	f.String("playurl", k.String("playurl"), "URL template to play mpd. %s will be replaced by MPD URL")
 	f.String("drmcfgfile", k.String("drmcfgfile"), "DRM config file path")
+	f.String("adasset", k.String("adasset"), "path to MPD of asset used for insertad_1 ad periods (default is the live asset itself)")
+	f.String("cmsdorigin", k.String("cmsdorigin"), "origin name in CMSD-Static headers (default livesim2)")
 
 	if err := f.Parse(args[1:]); err != nil {
 		return nil, fmt.Errorf("command line parse: %w", err)
@@ -261,3 +277,20 @@ func checkTLSParams(k *koanf.Koanf) error {
 		return fmt.Errorf("certpath and keypath must both be empty or set")
 	}
 }
+
+// parseSCTE35Schedules parses the named SCTE-35 schedules of the server configuration.
+// Names must not be valid scte35 URL values themselves, i.e. numbers or inline schedules.
+func parseSCTE35Schedules(patterns map[string]string) (map[string]scte35.Schedule, error) {
+	schedules := make(map[string]scte35.Schedule, len(patterns))
+	for name, pattern := range patterns {
+		if _, err := strconv.Atoi(name); err == nil || name == "" || strings.ContainsAny(name, ":/") {
+			return nil, fmt.Errorf("bad scte35 schedule name %q", name)
+		}
+		sched, err := scte35.ParseSchedule(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("scte35 schedule %q: %w", name, err)
+		}
+		schedules[name] = sched
+	}
+	return schedules, nil
+}
@@ -9,6 +9,8 @@ import (
 	"math"
 	"net/http"
 	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -19,6 +21,10 @@ type liveMPDType int
 
 const (
 	MAX_TIME_SHIFT_BUFFER_DEPTH_S = 48 * 3600
+	scte35CmdSpliceInsert         = "spliceinsert"
+	eventSigInband                = "inband"
+	eventSigMPD                   = "mpd"
+	eventSigBoth                  = "both"
 )
 
 const (
@@ -73,6 +79,7 @@ type ResponseConfig struct {
 	StopTimeS                    *int              `json:"StopTimeS,omitempty"`
 	TimeOffsetS                  *float64          `json:"TimeOffsetS,omitempty"`
 	InitSegAvailOffsetS          *int              `json:"InitSegAvailOffsetS,omitempty"`
+	ModuloPeriodMinutes          *int              `json:"ModuloPeriodMinutes,omitempty"`
 	TimeShiftBufferDepthS        *int              `json:"TimeShiftBufferDepthS,omitempty"`
 	MinimumUpdatePeriodS         *int              `json:"MinimumUpdatePeriodS,omitempty"`
 	PeriodsPerHour               *int              `json:"PeriodsPerHour,omitempty"`
@@ -80,7 +87,18 @@ type ResponseConfig struct {
 	EtpPeriodsPerHour            *int              `json:"EtpPeriodsPerHour,omitempty"`
 	EtpDuration                  *int              `json:"EtpDuration,omitempty"`
 	PeriodOffset                 *int              `json:"PeriodOffset,omitempty"`
-	SCTE35PerMinute              *int              `json:"SCTE35PerMinute,omitempty"`
+	SCTE35                       *scte35.Schedule  `json:"SCTE35,omitempty"`
+	SCTE35ScheduleName           string            `json:"SCTE35ScheduleName,omitempty"`
+	SCTE35Cmd                    string            `json:"SCTE35Cmd,omitempty"`
+	SCTE35Signal                 string            `json:"SCTE35Signal,omitempty"`
+	MPDExpiryLeadS               *int              `json:"MPDExpiryLeadS,omitempty"`
+	ID3IntervalS                 *int              `json:"ID3IntervalS,omitempty"`
+	ID3Text                      string            `json:"ID3Text,omitempty"`
+	CallbackIntervalS            *int              `json:"CallbackIntervalS,omitempty"`
+	CallbackSignal               string            `json:"CallbackSignal,omitempty"`
+	PrftDelayMS                  *int              `json:"PrftDelayMS,omitempty"`
+	ThrottleKbps                 *int              `json:"ThrottleKbps,omitempty"`
+	ThrottleTraceName            string            `json:"ThrottleTraceName,omitempty"`
 	StartNr                      *int              `json:"StartNr,omitempty"`
 	SuggestedPresentationDelayS  *int              `json:"SuggestedPresentationDelayS,omitempty"`
 	AvailabilityTimeOffsetS      float64           `json:"AvailabilityTimeOffsetS,omitempty"`
@@ -105,6 +123,11 @@ type ResponseConfig struct {
 	DRM                          string            `json:"DRM,omitempty"` // Includes ECCP as eccp-cbcs or eccp-cenc
 	SegStatusCodes               []SegStatusCodes  `json:"SegStatus,omitempty"`
 	Traffic                      []LossItvls       `json:"Traffic,omitempty"`
+	Steering                     []SteeringItvl    `json:"Steering,omitempty"`
+	CMSD                         []CMSDItvl        `json:"CMSD,omitempty"`
+	adAsset                      *asset
+	adMPDName                    string
+	throttleTrace                *throttleTrace
 }
 
 // SegStatusCodes configures regular extraordinary segment response codes
@@ -226,6 +249,140 @@ func baseURL(nr int) string {
 	return fmt.Sprintf("bu%d/", nr)
 }
 
+// pathwayID returns the content steering pathway ID (serviceLocation) for BaseURL nr.
+func pathwayID(nr int) string {
+	return strings.TrimSuffix(baseURL(nr), "/")
+}
+
+// SteeringItvl is an interval of a content steering script.
+type SteeringItvl struct {
+	DurS int
+	// Pathways is the BaseURL numbers in priority order
+	Pathways []int
+}
+
+// CreateSteeringScript creates steering intervals from a pattern like 01@30,10@20
+// (BaseURL 0 before 1 for 30s, then BaseURL 1 before 0 for 20s). The script is repeated.
+func CreateSteeringScript(pattern string) ([]SteeringItvl, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	itvls := make([]SteeringItvl, 0, strings.Count(pattern, ",")+1)
+	for _, s := range strings.Split(pattern, ",") {
+		order, durStr, ok := strings.Cut(s, "@")
+		if !ok || order == "" {
+			return nil, fmt.Errorf("invalid steering pattern %q", pattern)
+		}
+		dur, err := strconv.Atoi(durStr)
+		if err != nil || dur <= 0 {
+			return nil, fmt.Errorf("invalid steering pattern %q", pattern)
+		}
+		itvl := SteeringItvl{DurS: dur}
+		for i := 0; i < len(order); i++ {
+			nr := int(order[i] - '0')
+			if nr < 0 || nr > 9 || slices.Contains(itvl.Pathways, nr) {
+				return nil, fmt.Errorf("invalid steering pattern %q", pattern)
+			}
+			itvl.Pathways = append(itvl.Pathways, nr)
+		}
+		itvls = append(itvls, itvl)
+	}
+	return itvls, nil
+}
+
+// nrBaseURLs returns the number of BaseURLs needed for traffic patterns and content steering.
+func (rc *ResponseConfig) nrBaseURLs() int {
+	nr := len(rc.Traffic)
+	for _, itvl := range rc.Steering {
+		for _, p := range itvl.Pathways {
+			nr = max(nr, p+1)
+		}
+	}
+	return nr
+}
+
+// steeringAt returns the pathway priority at nowS and the number of seconds until it changes.
+func (rc *ResponseConfig) steeringAt(nowS int) (pathways []string, ttlS int) {
+	cycleS := 0
+	for _, itvl := range rc.Steering {
+		cycleS += itvl.DurS
+	}
+	rest := nowS % cycleS
+	for _, itvl := range rc.Steering {
+		if rest < itvl.DurS {
+			for _, nr := range itvl.Pathways {
+				pathways = append(pathways, pathwayID(nr))
+			}
+			return pathways, itvl.DurS - rest
+		}
+		rest -= itvl.DurS
+	}
+	return nil, 0 // Cannot happen
+}
+
+// CMSDItvl is an interval of a CMSD-Dynamic schedule.
+type CMSDItvl struct {
+	DurS    int
+	EtpKbps int
+	RttMS   int
+}
+
+// CreateCMSDScript creates CMSD-Dynamic intervals from a pattern like 5000:20@30,1000:80@30.
+// Each interval has an estimated throughput (kbps), an optional round-trip time (ms), and a duration (s).
+// A single interval without duration gives constant values.
+func CreateCMSDScript(pattern string) ([]CMSDItvl, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	parts := strings.Split(pattern, ",")
+	itvls := make([]CMSDItvl, 0, len(parts))
+	for _, p := range parts {
+		values, durStr, hasDur := strings.Cut(p, "@")
+		var itvl CMSDItvl
+		var err error
+		switch {
+		case hasDur:
+			itvl.DurS, err = strconv.Atoi(durStr)
+			if err != nil || itvl.DurS <= 0 {
+				return nil, fmt.Errorf("invalid cmsd pattern %q", pattern)
+			}
+		case len(parts) > 1:
+			return nil, fmt.Errorf("invalid cmsd pattern %q: duration needed for multiple intervals", pattern)
+		default:
+			itvl.DurS = 1
+		}
+		etpStr, rttStr, hasRtt := strings.Cut(values, ":")
+		itvl.EtpKbps, err = strconv.Atoi(etpStr)
+		if err != nil || itvl.EtpKbps <= 0 {
+			return nil, fmt.Errorf("invalid cmsd pattern %q", pattern)
+		}
+		if hasRtt {
+			itvl.RttMS, err = strconv.Atoi(rttStr)
+			if err != nil || itvl.RttMS <= 0 {
+				return nil, fmt.Errorf("invalid cmsd pattern %q", pattern)
+			}
+		}
+		itvls = append(itvls, itvl)
+	}
+	return itvls, nil
+}
+
+// cmsdAt returns the CMSD-Dynamic interval valid at nowS.
+func (rc *ResponseConfig) cmsdAt(nowS int) CMSDItvl {
+	cycleS := 0
+	for _, itvl := range rc.CMSD {
+		cycleS += itvl.DurS
+	}
+	rest := nowS % cycleS
+	for _, itvl := range rc.CMSD {
+		if rest < itvl.DurS {
+			return itvl
+		}
+		rest -= itvl.DurS
+	}
+	return CMSDItvl{} // Cannot happen
+}
+
 // NewResponseConfig returns a new ResponseConfig with default values.
 func NewResponseConfig() *ResponseConfig {
 	c := ResponseConfig{
@@ -263,6 +420,21 @@ func (rc *ResponseConfig) getAvailabilityTimeOffsetS() float64 {
 	return rc.AvailabilityTimeOffsetS
 }
 
+// setAtoFromChunkDur sets availabilityTimeOffset to segment duration minus chunk duration,
+// if a chunk duration is configured without an explicit availabilityTimeOffset.
+// The first chunk of a segment is then available as soon as it is produced.
+func (rc *ResponseConfig) setAtoFromChunkDur(segDurMS int) error {
+	if rc.ChunkDurS == nil || rc.AvailabilityTimeOffsetS != 0 {
+		return nil
+	}
+	chunkDurMS := int(math.Round(*rc.ChunkDurS * 1000))
+	if chunkDurMS > segDurMS {
+		return fmt.Errorf("chunk duration %dms is longer than segment duration %dms", chunkDurMS, segDurMS)
+	}
+	rc.AvailabilityTimeOffsetS = float64(segDurMS-chunkDurMS) / 1000
+	return nil
+}
+
 // getStartNr for MPD. Default value if not set is 1.
 func (rc *ResponseConfig) getStartNr() int {
 	// Default startNr is 1 according to spec, but can be overridden by actual value set in cfg.
@@ -272,6 +444,78 @@ func (rc *ResponseConfig) getStartNr() int {
 	return 1
 }
 
+// initAvailTimeMS returns the time when the init segments become available.
+// This is availabilityStartTime, or earlier if an init segment availability offset is set.
+func (rc *ResponseConfig) initAvailTimeMS() int {
+	availMS := rc.StartTimeS * 1000
+	if rc.InitSegAvailOffsetS != nil {
+		availMS -= *rc.InitSegAvailOffsetS * 1000
+	}
+	return availMS
+}
+
+// setModuloTimes sets the start and stop time of the modulo session that nowMS belongs to.
+// Every modulo period starts with a tenth of the period where the MPD has a future
+// availabilityStartTime. The session is then live for half the period, and is over
+// for the rest of the period. The MPD is available during the whole modulo period.
+func (rc *ResponseConfig) setModuloTimes(nowMS int) {
+	periodS := *rc.ModuloPeriodMinutes * 60
+	periodStartS := nowMS / 1000 / periodS * periodS
+	preLiveS := periodS / 10
+	rc.StartTimeS = periodStartS + preLiveS
+	rc.StopTimeS = Ptr(rc.StartTimeS + periodS/2)
+	rc.InitSegAvailOffsetS = Ptr(preLiveS)
+}
+
+// inModuloSession returns true if segments are available at nowMS for a modulo session.
+// After the stop time, segments remain available for a tenth of the modulo period, so
+// that the last segments can be fetched.
+func (rc *ResponseConfig) inModuloSession(nowMS int) bool {
+	if rc.ModuloPeriodMinutes == nil {
+		return true
+	}
+	graceMS := *rc.ModuloPeriodMinutes * 60 * 1000 / 10
+	return nowMS >= rc.StartTimeS*1000 && nowMS < *rc.StopTimeS*1000+graceMS
+}
+
+// getPeriodOffsetS returns the start of the first Period relative to availabilityStartTime in seconds.
+func (rc *ResponseConfig) getPeriodOffsetS() int {
+	if rc.PeriodOffset != nil {
+		return *rc.PeriodOffset
+	}
+	return 0
+}
+
+// scte35Inband returns true if SCTE-35 cues are sent as emsg boxes in the video segments.
+func (rc *ResponseConfig) scte35Inband() bool {
+	return rc.SCTE35 != nil && rc.SCTE35Signal != eventSigMPD
+}
+
+// scte35InMPD returns true if SCTE-35 cues are sent in Period EventStreams.
+func (rc *ResponseConfig) scte35InMPD() bool {
+	return rc.SCTE35 != nil && (rc.SCTE35Signal == eventSigMPD || rc.SCTE35Signal == eventSigBoth)
+}
+
+// callbackInband returns true if callback events are sent as emsg boxes in the video segments.
+func (rc *ResponseConfig) callbackInband() bool {
+	return rc.CallbackIntervalS != nil && rc.CallbackSignal != eventSigMPD
+}
+
+// callbackInMPD returns true if callback events are sent in Period EventStreams.
+func (rc *ResponseConfig) callbackInMPD() bool {
+	return rc.CallbackIntervalS != nil && (rc.CallbackSignal == eventSigMPD || rc.CallbackSignal == eventSigBoth)
+}
+
+// validEventSignal returns true if sig is a known event signalling mode.
+func validEventSignal(sig string) bool {
+	switch sig {
+	case eventSigInband, eventSigMPD, eventSigBoth:
+		return true
+	default:
+		return false
+	}
+}
+
 // processURLCfg returns all information that can be extracted from url
 func processURLCfg(confURL string, nowMS int) (*ResponseConfig, error) {
 	// Mimics configprocessor.process_url
@@ -308,31 +552,31 @@ cfgLoop:
 			cfg.StopTimeS = sc.AtoiPtr(key, val)
 			*cfg.StopTimeS += ms2S(nowMS)
 			cfg.AddLocationFlag = true
-		case "dur": // Adds a presentation duration for multiple periods
+		case "dur": // Adds a period duration. Multiple values are repeated in a cycle
 			cfg.PeriodDurations = append(cfg.PeriodDurations, sc.Atoi(key, val))
 		case "timeoffset": //Time offset in seconds versus NTP
 			cfg.TimeOffsetS = sc.Atof(key, val)
-		case "init": // Make the init segment available earlier
+		case "init": // Make the MPD and init segments available N seconds before availabilityStartTime
 			cfg.InitSegAvailOffsetS = sc.AtoiPtr(key, val)
 		case "tsbd": // Timeshift Buffer Depth
 			cfg.TimeShiftBufferDepthS = sc.AtoiPtr(key, val)
 		case "mup": //minimum update period (in s)
 			cfg.MinimumUpdatePeriodS = sc.AtoiPtr(key, val)
 		case "modulo": // Make a number of time-limited sessions every hour
-			return nil, fmt.Errorf("option %q not implemented", key)
-		case "tfdt": // Use 32-bit tfdt (which means that AST must be more recent as well)
+			cfg.ModuloPeriodMinutes = sc.AtoiPtr(key, val)
+		case "tfdt": // Use version 0 tfdt with 32-bit baseMediaDecodeTime (AST must be recent enough)
 			cfg.Tfdt32Flag = true
-		case "cont": // Continuous update of MPD AST and segNr
+		case "cont": // Continuous update of MPD availabilityStartTime and startNumber
 			cfg.ContUpdateFlag = true
 		case "periods": // Make n periods per hour
 			cfg.PeriodsPerHour = sc.AtoiPtr(key, val)
-		case "xlink": // Make periods access via xlink
+		case "xlink": // Make every N-th period a remote period accessed via xlink
 			cfg.XlinkPeriodsPerHour = sc.AtoiPtr(key, val)
 		case "etp": // Early terminated periods per hour
 			cfg.EtpPeriodsPerHour = sc.AtoiPtr(key, val)
-		case "etpDuration": // Add a presentation duration for multiple periods
+		case "etpDuration": // Duration in seconds of early-terminated periods (default half period)
 			cfg.EtpDuration = sc.AtoiPtr(key, val)
-		case "insertad": // insert an ad via xlink
+		case "insertad": // Insert ad periods at the SCTE-35 splice points
 			cfg.InsertAdFlag = true
 		case "continuous": // Only valid when periods_per_hour is set
 			cfg.ContMultiPeriodFlag = true
@@ -340,10 +584,28 @@ cfgLoop:
 			cfg.SegTimelineFlag = true
 		case "segtimelinenr":
 			cfg.SegTimelineNrFlag = true
-		case "peroff": // Set the period offset
+		case "peroff": // Shift Period@start and presentationTimeOffset by N seconds
 			cfg.PeriodOffset = sc.AtoiPtr(key, val)
-		case "scte35": // Signal this many SCTE-35 ad periods inband (emsg messages) every minute
-			cfg.SCTE35PerMinute = sc.AtoiPtr(key, val)
+		case "scte35": // SCTE-35 ad breaks: 1, 2, or 3 per minute, an inline schedule, or a server schedule name
+			cfg.SCTE35, cfg.SCTE35ScheduleName = sc.ParseSCTE35Schedule(key, val)
+		case "scte35cmd": // SCTE-35 command: spliceinsert (default) or a time_signal segmentation type
+			cfg.SCTE35Cmd = val
+		case "scte35sig": // SCTE-35 signalling: inband (default), mpd, or both
+			cfg.SCTE35Signal = val
+		case "mpdexpiry": // Signal MPD changes with MPD validity expiration emsg N seconds ahead
+			cfg.MPDExpiryLeadS = sc.AtoiPtr(key, val)
+		case "id3": // ID3 timed metadata emsg every N seconds in video segments
+			cfg.ID3IntervalS = sc.AtoiPtr(key, val)
+		case "id3text": // Text added to the ID3 timed metadata
+			cfg.ID3Text = val
+		case "callback": // DASH callback event every N seconds
+			cfg.CallbackIntervalS = sc.AtoiPtr(key, val)
+		case "callbacksig": // Callback event signalling: inband (default), mpd, or both
+			cfg.CallbackSignal = val
+		case "prft": // Insert prft boxes in all segments and chunks with an encoder delay of N milliseconds
+			cfg.PrftDelayMS = sc.AtoiPtr(key, val)
+		case "throttle": // Throttle responses to a constant rate in kbps, or following a server bandwidth trace name
+			cfg.ThrottleKbps, cfg.ThrottleTraceName = sc.ParseThrottle(key, val)
 		case "utc": // Get hyphen-separated list of utc-timing methods and make into list
 			cfg.UTCTimingMethods = sc.SplitUTCTimings(key, val)
 		case "snr": // Segment startNumber. -1 means default implicit number which ==  1
@@ -356,7 +618,7 @@ cfgLoop:
 			cfg.SuggestedPresentationDelayS = sc.AtoiPtr(key, val)
 		case "sidx": // Insert sidx in each segment
 			cfg.SidxFlag = true
-		case "segtimelineloss": // Segment timeline loss case
+		case "segtimelineloss": // Drop segments from SegmentTimeline and respond 404 for them
 			cfg.SegTimelineLossFlag = true
 		case "chunkdur": // chunk duration in seconds
 			cfg.ChunkDurS = sc.AtofPosPtr(key, val)
@@ -373,6 +635,10 @@ cfgLoop:
 			cfg.SegStatusCodes = sc.ParseSegStatusCodes(key, val)
 		case "traffic":
 			cfg.Traffic = sc.ParseLossItvls(key, val)
+		case "steering": // Content steering script
+			cfg.Steering = sc.ParseSteeringScript(key, val)
+		case "cmsd": // CMSD-Dynamic schedule
+			cfg.CMSD = sc.ParseCMSDScript(key, val)
 		case "drm":
 			cfg.DRM = val
 		case "eccp":
@@ -402,6 +668,18 @@ cfgLoop:
 	return cfg, nil
 }
 
+// verifyAssetConfig checks the parts of the configuration that depend on the asset.
+func verifyAssetConfig(cfg *ResponseConfig, a *asset) error {
+	if cfg.EtpPeriodsPerHour != nil {
+		for _, rep := range a.Reps {
+			if rep.PreEncrypted {
+				return fmt.Errorf("etp not supported for pre-encrypted content")
+			}
+		}
+	}
+	return nil
+}
+
 func verifyAndFillConfig(cfg *ResponseConfig, nowMS int) error {
 	if nowMS < 0 {
 		return fmt.Errorf("nowMS must be >= 0")
@@ -415,7 +693,7 @@ func verifyAndFillConfig(cfg *ResponseConfig, nowMS int) error {
 	if cfg.MinimumUpdatePeriodS != nil && *cfg.MinimumUpdatePeriodS <= 0 {
 		return fmt.Errorf("minimumUpdatePeriod must be > 0")
 	}
-	if cfg.getAvailabilityTimeOffsetS() > 0 && cfg.LatencyTargetMS == nil {
+	if (cfg.getAvailabilityTimeOffsetS() > 0 || cfg.ChunkDurS != nil) && cfg.LatencyTargetMS == nil {
 		cfg.LatencyTargetMS = Ptr(defaultLatencyTargetMS)
 	}
 	if cfg.TimeShiftBufferDepthS != nil {
@@ -424,13 +702,129 @@ func verifyAndFillConfig(cfg *ResponseConfig, nowMS int) error {
 			return fmt.Errorf("timeShiftBufferDepth %ds is not less than %ds", tsbd, MAX_TIME_SHIFT_BUFFER_DEPTH_S)
 		}
 	}
-	if cfg.ContMultiPeriodFlag && cfg.PeriodsPerHour == nil {
-		return fmt.Errorf("period continuity set, but not multiple periods per hour")
+	if len(cfg.PeriodDurations) > 0 {
+		if cfg.PeriodsPerHour != nil {
+			return fmt.Errorf("period durations cannot be combined with periods per hour")
+		}
+		for _, d := range cfg.PeriodDurations {
+			if d <= 0 {
+				return fmt.Errorf("period duration %ds must be > 0", d)
+			}
+		}
+	}
+	if cfg.ContMultiPeriodFlag && cfg.PeriodsPerHour == nil && len(cfg.PeriodDurations) == 0 {
+		return fmt.Errorf("period continuity set, but not multiple periods")
 	}
-	if cfg.SCTE35PerMinute != nil {
-		err := scte35.IsValidSCTE35Interval(*cfg.SCTE35PerMinute)
-		if err != nil {
-			return err
+	if len(cfg.Traffic) > 0 && cfg.nrBaseURLs() > len(cfg.Traffic) {
+		return fmt.Errorf("steering uses %d BaseURLs, but traffic has %d", cfg.nrBaseURLs(), len(cfg.Traffic))
+	}
+	if cfg.XlinkPeriodsPerHour != nil {
+		if cfg.PeriodsPerHour == nil {
+			return fmt.Errorf("xlink periods set, but not multiple periods per hour")
+		}
+		if *cfg.XlinkPeriodsPerHour <= 0 {
+			return fmt.Errorf("xlink value must be > 0")
+		}
+	}
+	if cfg.EtpPeriodsPerHour != nil {
+		if cfg.PeriodsPerHour == nil {
+			return fmt.Errorf("early-terminated periods set, but not multiple periods per hour")
+		}
+		etp := *cfg.EtpPeriodsPerHour
+		if etp <= 0 || etp > *cfg.PeriodsPerHour || *cfg.PeriodsPerHour%etp != 0 {
+			return fmt.Errorf("etp %d must be a divisor of periods per hour %d", etp, *cfg.PeriodsPerHour)
+		}
+		periodDur := 3600 / *cfg.PeriodsPerHour
+		if cfg.EtpDuration == nil {
+			cfg.EtpDuration = Ptr(periodDur / 2)
+		}
+		if *cfg.EtpDuration <= 0 || *cfg.EtpDuration >= periodDur {
+			return fmt.Errorf("etpDuration %ds must be shorter than period duration %ds", *cfg.EtpDuration, periodDur)
+		}
+	} else if cfg.EtpDuration != nil {
+		return fmt.Errorf("etpDuration set, but not etp")
+	}
+	if cfg.ModuloPeriodMinutes != nil {
+		mp := *cfg.ModuloPeriodMinutes
+		if mp <= 0 || 60%mp != 0 {
+			return fmt.Errorf("modulo %d must be a divisor of 60", mp)
+		}
+		if cfg.StartTimeS != 0 || cfg.StopTimeS != nil {
+			return fmt.Errorf("modulo cannot be combined with start or stop")
+		}
+		if cfg.InitSegAvailOffsetS != nil {
+			return fmt.Errorf("modulo cannot be combined with init")
+		}
+		cfg.setModuloTimes(nowMS)
+	}
+	if cfg.InitSegAvailOffsetS != nil && *cfg.InitSegAvailOffsetS < 0 {
+		return fmt.Errorf("init segment availability offset must be >= 0")
+	}
+	if cfg.SegTimelineLossFlag && !cfg.SegTimelineFlag {
+		return fmt.Errorf("segtimelineloss requires segtimeline")
+	}
+	if cfg.ContUpdateFlag {
+		if cfg.PeriodsPerHour != nil || len(cfg.PeriodDurations) > 0 || cfg.InsertAdFlag {
+			return fmt.Errorf("cont cannot be combined with multiple periods")
+		}
+		if cfg.PatchTTL > 0 {
+			return fmt.Errorf("cont cannot be combined with patch")
+		}
+		if cfg.getStartNr() < 0 {
+			return fmt.Errorf("cont requires a non-negative startNumber")
+		}
+	}
+	if cfg.PeriodOffset != nil && *cfg.PeriodOffset < 0 {
+		return fmt.Errorf("period offset must be >= 0")
+	}
+	if cfg.InsertAdFlag {
+		if cfg.SCTE35 == nil && cfg.SCTE35ScheduleName == "" {
+			return fmt.Errorf("insertad requires scte35 splice points")
+		}
+		if cfg.PeriodsPerHour != nil || len(cfg.PeriodDurations) > 0 {
+			return fmt.Errorf("insertad cannot be combined with periods")
+		}
+	}
+	if cfg.SCTE35Cmd != "" {
+		if cfg.SCTE35 == nil && cfg.SCTE35ScheduleName == "" {
+			return fmt.Errorf("scte35cmd requires scte35")
+		}
+		if _, ok := scte35.SegmentationStartTypes[cfg.SCTE35Cmd]; !ok && cfg.SCTE35Cmd != scte35CmdSpliceInsert {
+			return fmt.Errorf("unknown scte35cmd %q", cfg.SCTE35Cmd)
+		}
+	}
+	if cfg.MPDExpiryLeadS != nil && *cfg.MPDExpiryLeadS <= 0 {
+		return fmt.Errorf("mpdexpiry lead time must be positive")
+	}
+	if cfg.ID3IntervalS != nil && *cfg.ID3IntervalS <= 0 {
+		return fmt.Errorf("id3 interval must be positive")
+	}
+	if cfg.ID3Text != "" && cfg.ID3IntervalS == nil {
+		return fmt.Errorf("id3text requires id3")
+	}
+	if cfg.CallbackIntervalS != nil && *cfg.CallbackIntervalS <= 0 {
+		return fmt.Errorf("callback interval must be positive")
+	}
+	if cfg.CallbackSignal != "" {
+		if cfg.CallbackIntervalS == nil {
+			return fmt.Errorf("callbacksig requires callback")
+		}
+		if !validEventSignal(cfg.CallbackSignal) {
+			return fmt.Errorf("unknown callbacksig %q", cfg.CallbackSignal)
+		}
+	}
+	if cfg.PrftDelayMS != nil && *cfg.PrftDelayMS < 0 {
+		return fmt.Errorf("prft encoder delay must be >= 0")
+	}
+	if cfg.ThrottleKbps != nil && *cfg.ThrottleKbps <= 0 {
+		return fmt.Errorf("throttle rate must be > 0")
+	}
+	if cfg.SCTE35Signal != "" {
+		if cfg.SCTE35 == nil && cfg.SCTE35ScheduleName == "" {
+			return fmt.Errorf("scte35sig requires scte35")
+		}
+		if !validEventSignal(cfg.SCTE35Signal) {
+			return fmt.Errorf("unknown scte35sig %q", cfg.SCTE35Signal)
 		}
 	}
 	// We do not check here that the drm is one that has been configured,
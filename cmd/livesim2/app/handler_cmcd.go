@@ -0,0 +1,36 @@
+// Copyright 2023, DASH-Industry Forum. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.md file.
+
+package app
+
+import (
+	"net/http"
+	"time"
+)
+
+type cmcdInfo struct {
+	Host     string
+	Now      string
+	Sessions []cmcdSession
+}
+
+// cmcdHandlerFunc lists the CMCD data aggregated per session.
+// The response is JSON for query parameter format=json, and HTML otherwise.
+func (s *Server) cmcdHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	sessions := s.cmcd.list()
+	if r.URL.Query().Get("format") == "json" {
+		s.jsonResponse(w, sessions, http.StatusOK)
+		return
+	}
+	info := cmcdInfo{
+		Host:     fullHost(s.Cfg.Host, r),
+		Now:      time.Now().UTC().Format(time.RFC3339),
+		Sessions: sessions,
+	}
+	w.Header().Set("Content-Type", "text/html")
+	err := s.htmlTemplates.ExecuteTemplate(w, "cmcd.html", info)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
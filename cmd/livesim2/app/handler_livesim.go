@@ -39,7 +39,7 @@ func generateAndLogHttpError(log *slog.Logger, msg string, statusCode int) *erro
 	return &errorWithHttpType{msg, statusCode}
 }
 
-func cfgFromRequest(r *http.Request, log *slog.Logger) (nowMS int, cfg *ResponseConfig, errHT *errorWithHttpType) {
+func (s *Server) cfgFromRequest(r *http.Request, log *slog.Logger) (nowMS int, cfg *ResponseConfig, errHT *errorWithHttpType) {
 	uPath := r.URL.Path
 	u, err := url.Parse(uPath)
 	if err != nil {
@@ -74,13 +74,35 @@ func cfgFromRequest(r *http.Request, log *slog.Logger) (nowMS int, cfg *Response
 		return 0, nil, generateAndLogHttpError(log, msg, http.StatusBadRequest)
 	}
 
+	if cfg.SCTE35ScheduleName != "" {
+		sched, ok := s.scte35Schedules[cfg.SCTE35ScheduleName]
+		if !ok {
+			msg := fmt.Sprintf("unknown scte35 schedule %q", cfg.SCTE35ScheduleName)
+			return 0, nil, generateAndLogHttpError(log, msg, http.StatusBadRequest)
+		}
+		cfg.SCTE35 = &sched
+	}
+
+	switch {
+	case cfg.ThrottleKbps != nil:
+		cfg.throttleTrace = constantThrottleTrace(*cfg.ThrottleKbps)
+	case cfg.ThrottleTraceName != "":
+		trace, ok := s.throttleTraces[cfg.ThrottleTraceName]
+		if !ok {
+			msg := fmt.Sprintf("unknown throttle trace %q", cfg.ThrottleTraceName)
+			return 0, nil, generateAndLogHttpError(log, msg, http.StatusBadRequest)
+		}
+		cfg.throttleTrace = trace
+	}
+
 	if cfg.TimeOffsetS != nil {
 		offsetMS := int(*cfg.TimeOffsetS * 1000)
 		nowMS += offsetMS
 	}
 
-	if nowMS < cfg.StartTimeS*1000 {
-		tooEarlyMS := cfg.StartTimeS - nowMS
+	// The MPD and init segments may be available before availabilityStartTime
+	if availMS := cfg.initAvailTimeMS(); nowMS < availMS {
+		tooEarlyMS := availMS - nowMS
 		msg := fmt.Sprintf("%dms too early", tooEarlyMS)
 		return 0, nil, generateAndLogHttpError(log, msg, http.StatusTooEarly)
 	}
@@ -92,7 +114,7 @@ func cfgFromRequest(r *http.Request, log *slog.Logger) (nowMS int, cfg *Response
 // ?nowMS=... can be used to set the current time for testing.
 func (s *Server) livesimHandlerFunc(w http.ResponseWriter, r *http.Request) {
 	log := logging.SubLoggerWithRequestID(slog.Default(), r)
-	nowMS, cfg, errHT := cfgFromRequest(r, log)
+	nowMS, cfg, errHT := s.cfgFromRequest(r, log)
 	if errHT != nil {
 		http.Error(w, errHT.Error(), errHT.statusCode)
 		return
@@ -107,22 +129,55 @@ func (s *Server) livesimHandlerFunc(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, msg, http.StatusNotFound)
 		return
 	}
+	if err := verifyAssetConfig(cfg, a); err != nil {
+		log.Error("verifyAssetConfig", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	cfg.SetHost(s.Cfg.Host, r)
+	if cfg.throttleTrace != nil {
+		session := s.throttles.session(throttleSessionKey(r, cfg), cfg.throttleTrace, time.Now())
+		w = &throttledWriter{ResponseWriter: w, ctx: r.Context(), session: session}
+	}
 	switch filepath.Ext(r.URL.Path) {
 	case ".mpd":
 		_, mpdName := path.Split(contentPart)
+		if cfg.InsertAdFlag {
+			if err := s.setAdAsset(cfg); err != nil {
+				log.Error("setAdAsset", "err", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		setCMSDManifestHeaders(w, cfg, cmsdOrigin(s.Cfg), nowMS)
 		err := writeLiveMPD(log, w, cfg, s.Cfg.DrmCfg, a, mpdName, nowMS)
 		if err != nil {
 			log.Error("liveMPD", "err", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+	case ".m3u8":
+		_, playlistName := path.Split(contentPart)
+		setCMSDManifestHeaders(w, cfg, cmsdOrigin(s.Cfg), nowMS)
+		err := writeLiveHLS(r.Context(), w, cfg, s.Cfg.DrmCfg, a, playlistName, r.URL.Query(), nowMS)
+		if err != nil {
+			log.Error("liveHLS", "err", err)
+			switch {
+			case errors.Is(err, errNotFound):
+				http.Error(w, "Not Found", http.StatusNotFound)
+			case errors.Is(err, errBadRequest):
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			default:
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
 	case ".mp4", ".m4s", ".cmfv", ".cmfa", ".cmft", ".jpg", ".jpeg", ".m4v", ".m4a":
 		segmentPart := strings.TrimPrefix(contentPart, a.AssetPath) // includes heading slash
-		if len(cfg.Traffic) > 0 {
+		if cfg.nrBaseURLs() > 0 {
 			var patternNr int
 			patternNr, segmentPart = extractPattern(segmentPart)
-			if patternNr >= 0 {
+			if patternNr >= 0 && patternNr < len(cfg.Traffic) {
 				itvls := cfg.Traffic[patternNr]
 				switch itvls.StateAt(nowMS / 1000) {
 				case lossNo:
@@ -143,8 +198,21 @@ func (s *Server) livesimHandlerFunc(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 		}
-		code, err := writeSegment(r.Context(), w, log, cfg, s.Cfg.DrmCfg, s.assetMgr.vodFS, a, segmentPart[1:],
-			nowMS, s.textTemplates, false /*isLast */)
+		setCMSDSegmentHeaders(w, cfg, a, segmentPart[1:], cmsdOrigin(s.Cfg), nowMS)
+		var code int
+		var err error
+		if partStr := r.URL.Query().Get("part"); partStr != "" {
+			// LL-HLS partial segment
+			partNr, errAtoi := strconv.Atoi(partStr)
+			if errAtoi != nil {
+				http.Error(w, "bad part query", http.StatusBadRequest)
+				return
+			}
+			err = writePartSegment(r.Context(), log, w, cfg, s.Cfg.DrmCfg, s.assetMgr.vodFS, a, segmentPart[1:], partNr, nowMS)
+		} else {
+			code, err = writeSegment(r.Context(), w, log, cfg, s.Cfg.DrmCfg, s.assetMgr.vodFS, a, segmentPart[1:],
+				nowMS, s.textTemplates, false /*isLast */)
+		}
 		if err != nil {
 			log.Error("writeSegment", "code", code, "err", err)
 			var tooEarly errTooEarly
@@ -216,10 +284,29 @@ func writeLiveMPD(log *slog.Logger, w http.ResponseWriter, cfg *ResponseConfig,
 	if err != nil {
 		return fmt.Errorf("convertToLive: %w", err)
 	}
+	if cfg.callbackInMPD() {
+		addCallbackEventStreams(lMPD, cfg, nowMS)
+	}
 	size, err := lMPD.Write(buf, "  ", true)
 	if err != nil {
 		return err
 	}
+	if len(cfg.Steering) > 0 {
+		out, err := addContentSteering(buf.Bytes(), cfg, nowMS)
+		if err != nil {
+			return err
+		}
+		buf = bytes.NewBuffer(out)
+		size = len(out)
+	}
+	if cfg.scte35InMPD() {
+		out, err := addSCTE35EventStreams(buf.Bytes(), lMPD, a, cfg, nowMS)
+		if err != nil {
+			return err
+		}
+		buf = bytes.NewBuffer(out)
+		size = len(out)
+	}
 	w.Header().Set("Content-Length", strconv.Itoa(size))
 	w.Header().Set("Content-Type", "application/dash+xml")
 	n, err := w.Write(buf.Bytes())
@@ -236,11 +323,79 @@ func writeLiveMPD(log *slog.Logger, w http.ResponseWriter, cfg *ResponseConfig,
 	return nil
 }
 
+// writeLiveHLS writes a multivariant or media playlist depending on the playlist name.
+// A media playlist request with _HLS_msn (and _HLS_part) is blocked until the
+// requested segment (or part) is available.
+func writeLiveHLS(ctx context.Context, w http.ResponseWriter, cfg *ResponseConfig, drmCfg *drm.DrmConfig,
+	a *asset, playlistName string, q url.Values, nowMS int) error {
+	mpdName, repID, ok := a.parseHLSName(playlistName)
+	if !ok {
+		return fmt.Errorf("playlist %q: %w", playlistName, errNotFound)
+	}
+	var playlist string
+	var err error
+	if repID == "" {
+		playlist, err = LiveHLSMultivariant(a, mpdName, cfg, drmCfg, nowMS)
+	} else {
+		nowMS, err = blockHLSReload(ctx, a, repID, cfg, q, nowMS)
+		if err != nil {
+			return err
+		}
+		opts := hlsMediaOptions{skip: q.Get("_HLS_skip") == "YES"}
+		playlist, err = LiveHLSMedia(a, mpdName, repID, cfg, drmCfg, nowMS, opts)
+	}
+	if err != nil {
+		return fmt.Errorf("convertToHLS: %w", err)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(playlist)))
+	w.Header().Set("Content-Type", hlsContentType)
+	_, err = w.Write([]byte(playlist))
+	return err
+}
+
+// blockHLSReload waits until the segment and part given by _HLS_msn and _HLS_part are available.
+// Returns the updated nowMS.
+func blockHLSReload(ctx context.Context, a *asset, repID string, cfg *ResponseConfig, q url.Values, nowMS int) (int, error) {
+	msnStr, partStr := q.Get("_HLS_msn"), q.Get("_HLS_part")
+	if msnStr == "" {
+		if partStr != "" {
+			return 0, fmt.Errorf("_HLS_part without _HLS_msn: %w", errBadRequest)
+		}
+		return nowMS, nil
+	}
+	msn, err := strconv.Atoi(msnStr)
+	if err != nil || msn < 0 {
+		return 0, fmt.Errorf("_HLS_msn %q: %w", msnStr, errBadRequest)
+	}
+	partNr := -1
+	if partStr != "" {
+		partNr, err = strconv.Atoi(partStr)
+		if err != nil || partNr < 0 {
+			return 0, fmt.Errorf("_HLS_part %q: %w", partStr, errBadRequest)
+		}
+	}
+	readyMS := hlsPlaylistReadyMS(a, repID, cfg, msn, partNr, nowMS)
+	if readyMS <= nowMS {
+		return nowMS, nil
+	}
+	// The request is too far in the future if more than two segments ahead of the live edge
+	if readyMS-nowMS > 3*a.SegmentDurMS {
+		return 0, fmt.Errorf("_HLS_msn %d too far in the future: %w", msn, errBadRequest)
+	}
+	if err := sleepCtx(ctx, time.Duration(readyMS-nowMS)*time.Millisecond); err != nil {
+		return 0, err
+	}
+	return readyMS, nil
+}
+
 // writeSegment writes a segment to the response writer, but may also return a special status code if configured.
 func writeSegment(ctx context.Context, w http.ResponseWriter, log *slog.Logger, cfg *ResponseConfig, drmCfg *drm.DrmConfig,
 	vodFS fs.FS, a *asset, segmentPart string, nowMS int, tt *template.Template, isLast bool) (code int, err error) {
 	// First check if init segment and return
 	log.Debug("writeSegment", "segmentPart", segmentPart)
+	if err := cfg.setAtoFromChunkDur(a.SegmentDurMS); err != nil {
+		return 0, err
+	}
 	isInitSegment, err := writeInitSegment(log, w, cfg, drmCfg, a, segmentPart)
 	if err != nil {
 		return 0, fmt.Errorf("writeInitSegment: %w", err)
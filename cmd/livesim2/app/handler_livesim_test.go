@@ -152,6 +152,39 @@ func TestFetches(t *testing.T) {
 			wantedStatusCode:  http.StatusOK,
 			wantedContentType: `application/mp4`,
 		},
+		{
+			desc:              "hls multivariant playlist",
+			url:               "testpic_2s/Manifest.m3u8?nowMS=610000",
+			params:            "",
+			wantedStatusCode:  http.StatusOK,
+			wantedContentType: `application/vnd.apple.mpegurl`,
+		},
+		{
+			desc:              "hls media playlist",
+			url:               "testpic_2s/Manifest_V300.m3u8?nowMS=610000",
+			params:            "",
+			wantedStatusCode:  http.StatusOK,
+			wantedContentType: `application/vnd.apple.mpegurl`,
+		},
+		{
+			desc:             "hls media playlist for unknown representation",
+			url:              "testpic_2s/Manifest_V999.m3u8?nowMS=610000",
+			params:           "",
+			wantedStatusCode: http.StatusNotFound,
+		},
+		{
+			desc:              "ll-hls part",
+			url:               "testpic_2s/V300/50.m4s?part=0&nowMS=100700",
+			params:            "chunkdur_0.5/",
+			wantedStatusCode:  http.StatusOK,
+			wantedContentType: `video/mp4`,
+		},
+		{
+			desc:             "ll-hls blocking reload too far ahead",
+			url:              "testpic_2s/Manifest_V300.m3u8?_HLS_msn=100&nowMS=100700",
+			params:           "chunkdur_0.5/",
+			wantedStatusCode: http.StatusBadRequest,
+		},
 		{
 			desc:              "encrypted init segment",
 			url:               "testpic_2s/V300/init.mp4",
@@ -187,6 +220,55 @@ func TestFetches(t *testing.T) {
 			wantedStatusCode:  425,
 			wantedContentType: `video/mp4`,
 		},
+		{
+			desc:              "mpd before availabilityStartTime",
+			url:               "testpic_2s/Manifest.mpd?nowMS=80000",
+			params:            "start_100/",
+			wantedStatusCode:  425,
+			wantedContentType: `application/dash+xml`,
+		},
+		{
+			desc:              "mpd available early",
+			url:               "testpic_2s/Manifest.mpd?nowMS=80000",
+			params:            "start_100/init_30/",
+			wantedStatusCode:  http.StatusOK,
+			wantedContentType: `application/dash+xml`,
+		},
+		{
+			desc:              "init segment too early",
+			url:               "testpic_2s/V300/init.mp4?nowMS=60000",
+			params:            "start_100/init_30/",
+			wantedStatusCode:  425,
+			wantedContentType: `video/mp4`,
+		},
+		{
+			desc:              "init segment available early",
+			url:               "testpic_2s/V300/init.mp4?nowMS=80000",
+			params:            "start_100/init_30/",
+			wantedStatusCode:  http.StatusOK,
+			wantedContentType: `video/mp4`,
+		},
+		{
+			desc:              "encrypted init segment available early",
+			url:               "testpic_2s/V300/init.mp4?nowMS=80000",
+			params:            "start_100/init_30/eccp_cbcs/",
+			wantedStatusCode:  http.StatusOK,
+			wantedContentType: `video/mp4`,
+		},
+		{
+			desc:              "timesubs init segment available early",
+			url:               "testpic_2s/timestpp-en/init.mp4?nowMS=80000",
+			params:            "start_100/init_30/timesubsstpp_en/",
+			wantedStatusCode:  http.StatusOK,
+			wantedContentType: `application/mp4`,
+		},
+		{
+			desc:              "media segment before availabilityStartTime",
+			url:               "testpic_2s/V300/0.m4s?nowMS=80000",
+			params:            "start_100/init_30/",
+			wantedStatusCode:  425,
+			wantedContentType: `video/mp4`,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -0,0 +1,122 @@
+// Copyright 2023, DASH-Industry Forum. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.md file.
+
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Dash-Industry-Forum/livesim2/pkg/logging"
+)
+
+// SteeringManifest is a DASH-IF content steering manifest.
+type SteeringManifest struct {
+	Version         int      `json:"VERSION"`
+	TTL             int      `json:"TTL"`
+	ReloadURI       string   `json:"RELOAD-URI,omitempty"`
+	PathwayPriority []string `json:"PATHWAY-PRIORITY"`
+}
+
+// steeringHandlerFunc returns a content steering manifest following the steering script.
+// The path is the MPD path prefixed with /steering.
+func (s *Server) steeringHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	log := logging.SubLoggerWithRequestID(slog.Default(), r)
+	r.URL.Path = strings.TrimPrefix(r.URL.Path, "/steering")
+	nowMS, cfg, errHT := s.cfgFromRequest(r, log)
+	if errHT != nil {
+		http.Error(w, errHT.Error(), errHT.statusCode)
+		return
+	}
+	if len(cfg.Steering) == 0 {
+		http.Error(w, "no steering configured", http.StatusNotFound)
+		return
+	}
+	cfg.SetHost(s.Cfg.Host, r)
+	sm := createSteeringManifest(cfg, nowMS)
+	b, err := json.Marshal(sm)
+	if err != nil {
+		log.Error("steering manifest", "err", err)
+		http.Error(w, "steering manifest", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	_, err = w.Write(b)
+	if err != nil {
+		log.Error("Write", "err", err)
+	}
+}
+
+// createSteeringManifest returns the steering manifest at nowMS.
+// TTL is the time until the next change of pathway priority.
+func createSteeringManifest(cfg *ResponseConfig, nowMS int) SteeringManifest {
+	pathways, ttlS := cfg.steeringAt(nowMS / 1000)
+	return SteeringManifest{
+		Version:         1,
+		TTL:             ttlS,
+		ReloadURI:       steeringURL(cfg),
+		PathwayPriority: pathways,
+	}
+}
+
+// steeringURL returns the URL of the steering server for the MPD.
+func steeringURL(cfg *ResponseConfig) string {
+	return fmt.Sprintf("%s/steering%s", cfg.Host, strings.Join(cfg.URLParts, "/"))
+}
+
+// addContentSteering inserts a ContentSteering element before the first Period of the serialized MPD.
+// The element is not part of the MPD struct, so it cannot be added before serialization.
+func addContentSteering(mpdXML []byte, cfg *ResponseConfig, nowMS int) ([]byte, error) {
+	idx := indexPeriodStart(mpdXML, 0)
+	if idx < 0 {
+		return nil, fmt.Errorf("no Period in MPD")
+	}
+	// Keep the indentation of the Period if it starts its own line
+	lineStart := bytes.LastIndexByte(mpdXML[:idx], '\n') + 1
+	indent := mpdXML[lineStart:idx]
+	indented := len(bytes.TrimSpace(indent)) == 0
+	pathways, _ := cfg.steeringAt(nowMS / 1000)
+	var el bytes.Buffer
+	el.WriteString(`<ContentSteering defaultServiceLocation="`)
+	if err := xml.EscapeText(&el, []byte(pathways[0])); err != nil {
+		return nil, err
+	}
+	el.WriteString(`" queryBeforeStart="true">`)
+	if err := xml.EscapeText(&el, []byte(steeringURL(cfg))); err != nil {
+		return nil, err
+	}
+	el.WriteString("</ContentSteering>")
+	if indented {
+		el.WriteString("\n")
+		el.Write(indent)
+	}
+	out := make([]byte, 0, len(mpdXML)+el.Len())
+	out = append(out, mpdXML[:idx]...)
+	out = append(out, el.Bytes()...)
+	out = append(out, mpdXML[idx:]...)
+	return out, nil
+}
+
+// indexPeriodStart returns the index of the next Period start tag at or after pos, or -1.
+func indexPeriodStart(mpdXML []byte, pos int) int {
+	for {
+		idx := bytes.Index(mpdXML[pos:], []byte("<Period"))
+		if idx < 0 {
+			return -1
+		}
+		idx += pos
+		next := idx + len("<Period")
+		if next < len(mpdXML) && (mpdXML[next] == ' ' || mpdXML[next] == '>') {
+			return idx
+		}
+		pos = next
+	}
+}
@@ -0,0 +1,156 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Dash-Industry-Forum/livesim2/pkg/logging"
+	m "github.com/Eyevinn/dash-mpd/mpd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateSteeringScript(t *testing.T) {
+	cases := []struct {
+		pattern   string
+		wanted    []SteeringItvl
+		wantedErr bool
+	}{
+		{pattern: "01@30,10@20", wanted: []SteeringItvl{{DurS: 30, Pathways: []int{0, 1}}, {DurS: 20, Pathways: []int{1, 0}}}},
+		{pattern: "2@10", wanted: []SteeringItvl{{DurS: 10, Pathways: []int{2}}}},
+		{pattern: "01", wantedErr: true},
+		{pattern: "01@0", wantedErr: true},
+		{pattern: "00@10", wantedErr: true},
+		{pattern: "0a@10", wantedErr: true},
+	}
+	for _, tc := range cases {
+		got, err := CreateSteeringScript(tc.pattern)
+		if tc.wantedErr {
+			require.Error(t, err, tc.pattern)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, tc.wanted, got)
+	}
+	_, err := processURLCfg("/livesim2/traffic_u20d10/steering_01@30/testpic_2s/Manifest.mpd", 0)
+	require.Error(t, err, "steering needs two BaseURLs, but traffic has one")
+}
+
+func TestContentSteering(t *testing.T) {
+	cfg := ServerConfig{
+		VodRoot:   "testdata/assets",
+		TimeoutS:  0,
+		LogFormat: logging.LogDiscard,
+	}
+	err := logging.InitSlog(cfg.LogLevel, cfg.LogFormat)
+	require.NoError(t, err)
+	server, err := SetupServer(context.Background(), &cfg)
+	require.NoError(t, err)
+	ts := httptest.NewServer(server.Router)
+	defer ts.Close()
+
+	mpdPath := "/livesim2/steering_01@30,10@20/testpic_2s/Manifest.mpd"
+	resp, body := testFullRequest(t, ts, "GET", mpdPath+"?nowMS=100000", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	bodyStr := string(body)
+	wantedSteering := `<ContentSteering defaultServiceLocation="bu0" queryBeforeStart="true">` +
+		ts.URL + "/steering" + mpdPath + "</ContentSteering>"
+	require.Contains(t, bodyStr, wantedSteering)
+	require.Less(t, strings.Index(bodyStr, "<ContentSteering"), strings.Index(bodyStr, "<Period"))
+	mpd, err := m.MPDFromBytes(body)
+	require.NoError(t, err)
+	baseURLs := mpd.Periods[0].BaseURLs
+	require.Equal(t, 2, len(baseURLs))
+	for i, bu := range baseURLs {
+		require.Equal(t, baseURL(i), string(bu.Value))
+		require.Equal(t, pathwayID(i), bu.ServiceLocation)
+	}
+
+	testCases := []struct {
+		desc           string
+		nowMS          string
+		wantedPriority []string
+		wantedTTL      int
+	}{
+		{desc: "first interval", nowMS: "100000", wantedPriority: []string{"bu0", "bu1"}, wantedTTL: 30},
+		{desc: "second interval", nowMS: "140000", wantedPriority: []string{"bu1", "bu0"}, wantedTTL: 10},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			resp, body := testFullRequest(t, ts, "GET", "/steering"+mpdPath+"?nowMS="+tc.nowMS, nil)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+			var sm SteeringManifest
+			err := json.Unmarshal(body, &sm)
+			require.NoError(t, err)
+			require.Equal(t, 1, sm.Version)
+			require.Equal(t, tc.wantedPriority, sm.PathwayPriority)
+			require.Equal(t, tc.wantedTTL, sm.TTL)
+			require.Equal(t, ts.URL+"/steering"+mpdPath, sm.ReloadURI)
+		})
+	}
+
+	segPath := "/livesim2/steering_01@30,10@20/testpic_2s/bu1/V300/300.m4s?nowMS=610000"
+	resp, _ = testFullRequest(t, ts, "GET", segPath, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = testFullRequest(t, ts, "GET", "/steering/livesim2/testpic_2s/Manifest.mpd", nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestAddContentSteering(t *testing.T) {
+	cfg, err := processURLCfg("/livesim2/steering_10@30/testpic_2s/Manifest.mpd", 0)
+	require.NoError(t, err)
+	cfg.Host = "http://localhost"
+	wantedURL := "http://localhost/steering/livesim2/steering_10@30/testpic_2s/Manifest.mpd"
+	cases := []struct {
+		desc      string
+		mpd       string
+		wantedErr string
+	}{
+		{
+			desc: "indented",
+			mpd: "<MPD xmlns=\"urn:mpeg:dash:schema:mpd:2011\" type=\"static\">\n" +
+				"  <Period id=\"p0\" start=\"PT0S\"></Period>\n</MPD>\n",
+		},
+		{
+			desc: "element name starting with Period before Period",
+			mpd: "<MPD xmlns=\"urn:mpeg:dash:schema:mpd:2011\" type=\"static\">" +
+				"<ProgramInformation><PeriodInfo>x</PeriodInfo></ProgramInformation>" +
+				"<Period id=\"p0\" start=\"PT0S\"></Period><Period id=\"p1\" start=\"PT10S\"></Period></MPD>",
+		},
+		{
+			desc:      "no Period",
+			mpd:       "<MPD xmlns=\"urn:mpeg:dash:schema:mpd:2011\" type=\"static\"><PeriodInfo/></MPD>",
+			wantedErr: "no Period in MPD",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			out, err := addContentSteering([]byte(tc.mpd), cfg, 0)
+			if tc.wantedErr != "" {
+				require.EqualError(t, err, tc.wantedErr)
+				return
+			}
+			require.NoError(t, err)
+			mpd, err := m.MPDFromBytes(out)
+			require.NoError(t, err)
+			require.Equal(t, "p0", mpd.Periods[0].Id)
+			var steering struct {
+				ContentSteering struct {
+					DefaultServiceLocation string `xml:"defaultServiceLocation,attr"`
+					QueryBeforeStart       bool   `xml:"queryBeforeStart,attr"`
+					URL                    string `xml:",chardata"`
+				}
+			}
+			err = xml.Unmarshal(out, &steering)
+			require.NoError(t, err)
+			require.Equal(t, "bu1", steering.ContentSteering.DefaultServiceLocation)
+			require.True(t, steering.ContentSteering.QueryBeforeStart)
+			require.Equal(t, wantedURL, steering.ContentSteering.URL)
+		})
+	}
+}
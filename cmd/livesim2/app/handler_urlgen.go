@@ -144,11 +144,13 @@ type urlGenData struct {
 	UTCTiming                   string
 	Periods                     string   // number of periods per hour (1-60)
 	Continuous                  bool     // period continuity signaling
+	Xlink                       string   // every N-th period is a remote xlink period
 	StartNR                     string   // startNumber (default=0) -1 translates to no value in MPD (fallback to default = 1)
 	Start                       string   // sets timeline start (and availabilityStartTime) relative to Epoch (in seconds)
 	Stop                        string   // sets stop-time for time-limited event (in seconds)
 	StartRel                    string   // sets timeline start (and availabilityStartTime) relative to now (in seconds). Normally negative value.
 	StopRel                     string   // sets stop-time for time-limited event relative to now (in seconds)
+	Modulo                      string   // time-limited sessions repeating every N minutes (N divides 60)
 	Scte35Var                   string   // SCTE-35 insertion variant
 	PatchTTL                    string   // MPD Patch TTL  inv value in seconds (> 0 to be valid))
 	StatusCodes                 string   // comma-separated list of response code patterns to return
@@ -291,6 +293,11 @@ func createURL(r *http.Request, aInfo assetsInfo, drmPkgs []*drm.Package) urlGen
 		data.Continuous = true
 		sb.WriteString("continuous_1/")
 	}
+	xlink := q.Get("xlink")
+	if xlink != "" {
+		data.Xlink = xlink
+		sb.WriteString(fmt.Sprintf("xlink_%s/", xlink))
+	}
 	chunkDur := q.Get("chunkdur")
 	if chunkDur != "" {
 		data.ChunkDur = chunkDur
@@ -336,6 +343,11 @@ func createURL(r *http.Request, aInfo assetsInfo, drmPkgs []*drm.Package) urlGen
 		data.StopRel = stopRel
 		sb.WriteString(fmt.Sprintf("stoprel_%s/", stopRel))
 	}
+	modulo := q.Get("modulo")
+	if modulo != "" {
+		data.Modulo = modulo
+		sb.WriteString(fmt.Sprintf("modulo_%s/", modulo))
+	}
 	timeSubsStpp := q.Get("timesubsstpp")
 	if timeSubsStpp != "" {
 		data.TimeSubsStpp = timeSubsStpp
@@ -0,0 +1,85 @@
+// Copyright 2023, DASH-Industry Forum. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.md file.
+
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/Dash-Industry-Forum/livesim2/pkg/drm"
+	"github.com/Dash-Industry-Forum/livesim2/pkg/logging"
+	"github.com/Eyevinn/dash-mpd/xml"
+)
+
+// xlinkHandlerFunc returns a resolved remote Period.
+// The path is the MPD path prefixed with /xlink and the query parameter period is the Period id.
+func (s *Server) xlinkHandlerFunc(w http.ResponseWriter, r *http.Request) {
+	log := logging.SubLoggerWithRequestID(slog.Default(), r)
+	periodID := r.URL.Query().Get("period")
+	if periodID == "" {
+		log.Warn("period query is required, but not provided in xlink request")
+		http.Error(w, "period query is required", http.StatusBadRequest)
+		return
+	}
+	r.URL.Path = strings.TrimPrefix(r.URL.Path, "/xlink")
+	nowMS, cfg, errHT := s.cfgFromRequest(r, log)
+	if errHT != nil {
+		http.Error(w, errHT.Error(), errHT.statusCode)
+		return
+	}
+	contentPart := cfg.URLContentPart()
+	a, ok := s.assetMgr.findAsset(contentPart)
+	if !ok {
+		msg := fmt.Sprintf("unknown asset %q", contentPart)
+		log.Error(msg)
+		http.Error(w, msg, http.StatusNotFound)
+		return
+	}
+	cfg.SetHost(s.Cfg.Host, r)
+	if cfg.InsertAdFlag {
+		if err := s.setAdAsset(cfg); err != nil {
+			log.Error("setAdAsset", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	_, mpdName := path.Split(contentPart)
+	b, err := resolveXlinkPeriod(a, mpdName, cfg, s.Cfg.DrmCfg, nowMS, periodID)
+	switch {
+	case errors.Is(err, errNotFound):
+		http.Error(w, fmt.Sprintf("period %q not found", periodID), http.StatusNotFound)
+		return
+	case err != nil:
+		log.Error("resolveXlinkPeriod", "err", err)
+		http.Error(w, "resolveXlinkPeriod", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	_, err = w.Write(b)
+	if err != nil {
+		log.Error("Write", "err", err)
+	}
+}
+
+// resolveXlinkPeriod generates the live MPD without xlink and returns the Period with periodID as XML.
+func resolveXlinkPeriod(a *asset, mpdName string, cfg *ResponseConfig, drmCfg *drm.DrmConfig, nowMS int, periodID string) ([]byte, error) {
+	cfg.XlinkPeriodsPerHour = nil
+	lMPD, err := LiveMPD(a, mpdName, cfg, drmCfg, nowMS)
+	if err != nil {
+		return nil, fmt.Errorf("liveMPD: %w", err)
+	}
+	for _, p := range lMPD.Periods {
+		if p.Id == periodID {
+			return xml.MarshalIndent(p, "", "  ")
+		}
+	}
+	return nil, errNotFound
+}
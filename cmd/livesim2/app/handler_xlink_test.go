@@ -0,0 +1,78 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Dash-Industry-Forum/livesim2/pkg/logging"
+	m "github.com/Eyevinn/dash-mpd/mpd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestXlinkPeriods(t *testing.T) {
+	cfg := ServerConfig{
+		VodRoot:   "testdata/assets",
+		TimeoutS:  0,
+		LogFormat: logging.LogDiscard,
+	}
+	err := logging.InitSlog(cfg.LogLevel, cfg.LogFormat)
+	require.NoError(t, err)
+	server, err := SetupServer(context.Background(), &cfg)
+	require.NoError(t, err)
+	ts := httptest.NewServer(server.Router)
+	defer ts.Close()
+
+	mpdPath := "/livesim2/periods_60/xlink_2/testpic_2s/Manifest.mpd"
+	resp, body := testFullRequest(t, ts, "GET", mpdPath+"?nowMS=1001000", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	mpd, err := m.MPDFromBytes(body)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(mpd.Periods))
+	p15, p16 := mpd.Periods[0], mpd.Periods[1]
+	require.Equal(t, "P15", p15.Id)
+	require.Equal(t, "", p15.XlinkHref)
+	require.Greater(t, len(p15.AdaptationSets), 0)
+	require.Equal(t, "P16", p16.Id)
+	require.Equal(t, ts.URL+"/xlink"+mpdPath+"?period=P16", p16.XlinkHref)
+	require.Equal(t, "onLoad", p16.XlinkActuate)
+	require.Equal(t, 0, len(p16.AdaptationSets))
+
+	testCases := []struct {
+		desc             string
+		url              string
+		wantedStatusCode int
+		wantedStart      string
+	}{
+		{
+			desc:             "resolve xlink period",
+			url:              "/xlink" + mpdPath + "?period=P16&nowMS=1001000",
+			wantedStatusCode: http.StatusOK,
+			wantedStart:      `<Period id="P16" start="PT16M">`,
+		},
+		{
+			desc:             "period not in MPD",
+			url:              "/xlink" + mpdPath + "?period=P3&nowMS=1001000",
+			wantedStatusCode: http.StatusNotFound,
+		},
+		{
+			desc:             "no period query",
+			url:              "/xlink" + mpdPath + "?nowMS=1001000",
+			wantedStatusCode: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			resp, body := testFullRequest(t, ts, "GET", tc.url, nil)
+			require.Equal(t, tc.wantedStatusCode, resp.StatusCode)
+			if tc.wantedStatusCode != http.StatusOK {
+				return
+			}
+			require.Equal(t, "application/xml", resp.Header.Get("Content-Type"))
+			require.True(t, strings.HasPrefix(string(body), tc.wantedStart), string(body))
+			require.Contains(t, string(body), "<AdaptationSet")
+		})
+	}
+}
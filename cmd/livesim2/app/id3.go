@@ -0,0 +1,74 @@
+// Copyright 2023, DASH-Industry Forum. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.md file.
+
+package app
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+
+	"github.com/Dash-Industry-Forum/livesim2/pkg/id3"
+	m "github.com/Eyevinn/dash-mpd/mpd"
+	"github.com/Eyevinn/mp4ff/mp4"
+)
+
+// id3PrivOwner is the owner identifier of the PRIV frame with the wall-clock time.
+const id3PrivOwner = "https://github.com/Dash-Industry-Forum/livesim2"
+
+// id3Emsgs returns ID3 emsg boxes for the segment [segStart, segEnd) with number segNr.
+// There is one event every cfg.ID3IntervalS seconds. The event ID is the event time in seconds.
+// The ID3 tag has TXXX frames with wall-clock time, segment number, and cfg.ID3Text,
+// and a PRIV frame with the wall-clock time as 64-bit milliseconds since 1970.
+func id3Emsgs(cfg *ResponseConfig, segNr uint32, segStart, segEnd, timescale uint64) ([]*mp4.EmsgBox, error) {
+	interval := uint64(*cfg.ID3IntervalS) * timescale
+	var emsgs []*mp4.EmsgBox
+	for t := (segStart + interval - 1) / interval * interval; t < segEnd; t += interval {
+		wallClockMS := int64(cfg.StartTimeS)*1000 + int64(t*1000/timescale)
+		payload, err := id3Payload(wallClockMS, segNr, cfg.ID3Text)
+		if err != nil {
+			return nil, fmt.Errorf("id3: %w", err)
+		}
+		emsgs = append(emsgs, &mp4.EmsgBox{
+			Version:          1,
+			TimeScale:        uint32(timescale),
+			PresentationTime: t,
+			ID:               uint32(t / timescale),
+			SchemeIDURI:      id3.SchemeIDURI,
+			Value:            "",
+			MessageData:      payload,
+		})
+	}
+	return emsgs, nil
+}
+
+// id3Payload returns an ID3 tag with the wall-clock time, segment number, and optional text.
+func id3Payload(wallClockMS int64, segNr uint32, text string) ([]byte, error) {
+	var frames [][]byte
+	timeFrame, err := id3.TXXXFrame("time", string(m.ConvertToDateTimeMS(wallClockMS)))
+	if err != nil {
+		return nil, err
+	}
+	frames = append(frames, timeFrame)
+	nrFrame, err := id3.TXXXFrame("segment", strconv.FormatUint(uint64(segNr), 10))
+	if err != nil {
+		return nil, err
+	}
+	frames = append(frames, nrFrame)
+	if text != "" {
+		textFrame, err := id3.TXXXFrame("text", text)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, textFrame)
+	}
+	privData := make([]byte, 8)
+	binary.BigEndian.PutUint64(privData, uint64(wallClockMS))
+	privFrame, err := id3.PRIVFrame(id3PrivOwner, privData)
+	if err != nil {
+		return nil, err
+	}
+	frames = append(frames, privFrame)
+	return id3.Tag(frames...)
+}
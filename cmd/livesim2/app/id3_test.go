@@ -0,0 +1,95 @@
+// Copyright 2023, DASH-Industry Forum. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.md file.
+
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Dash-Industry-Forum/livesim2/pkg/id3"
+	"github.com/Dash-Industry-Forum/livesim2/pkg/logging"
+	"github.com/stretchr/testify/require"
+)
+
+func TestID3Emsgs(t *testing.T) {
+	cfg := &ResponseConfig{StartTimeS: 10, ID3IntervalS: Ptr(3), ID3Text: "hello"}
+	timescale := uint64(1000)
+	testCases := []struct {
+		desc      string
+		segStart  uint64
+		segEnd    uint64
+		wantedIDs []uint32
+	}{
+		{desc: "event at start", segStart: 0, segEnd: 2000, wantedIDs: []uint32{0}},
+		{desc: "no event", segStart: 4000, segEnd: 6000},
+		{desc: "event inside", segStart: 2000, segEnd: 4000, wantedIDs: []uint32{3}},
+		{desc: "two events", segStart: 6000, segEnd: 12_000, wantedIDs: []uint32{6, 9}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			emsgs, err := id3Emsgs(cfg, 7, tc.segStart, tc.segEnd, timescale)
+			require.NoError(t, err)
+			require.Len(t, emsgs, len(tc.wantedIDs))
+			for i, e := range emsgs {
+				require.Equal(t, tc.wantedIDs[i], e.ID)
+				require.Equal(t, uint64(e.ID)*timescale, e.PresentationTime)
+				require.Equal(t, id3.SchemeIDURI, e.SchemeIDURI)
+				require.Equal(t, "ID3", string(e.MessageData[:3]))
+			}
+		})
+	}
+	payload, err := id3Payload(13_000, 7, "hello")
+	require.NoError(t, err)
+	for _, s := range []string{"TXXX", "time\x001970-01-01T00:00:13Z", "segment\x007", "text\x00hello", "PRIV", id3PrivOwner} {
+		require.Contains(t, string(payload), s)
+	}
+}
+
+func TestID3Segments(t *testing.T) {
+	cfg := ServerConfig{
+		VodRoot:   "testdata/assets",
+		TimeoutS:  0,
+		LogFormat: logging.LogDiscard,
+	}
+	err := logging.InitSlog(cfg.LogLevel, cfg.LogFormat)
+	require.NoError(t, err)
+	server, err := SetupServer(context.Background(), &cfg)
+	require.NoError(t, err)
+	ts := httptest.NewServer(server.Router)
+	defer ts.Close()
+
+	testCases := []struct {
+		desc         string
+		url          string
+		wantedCode   int
+		wantedID3    bool
+		wantedInBody string
+	}{
+		{desc: "MPD", url: "/livesim2/id3_4/testpic_2s/Manifest.mpd", wantedCode: http.StatusOK, wantedID3: true,
+			wantedInBody: `<InbandEventStream schemeIdUri="https://aomedia.org/emsg/ID3"`},
+		{desc: "segment with event", url: "/livesim2/id3_4/id3text_hello/testpic_2s/V300/40.m4s", wantedCode: http.StatusOK,
+			wantedID3: true, wantedInBody: "hello"},
+		{desc: "segment without event", url: "/livesim2/id3_4/testpic_2s/V300/41.m4s", wantedCode: http.StatusOK},
+		{desc: "audio segment", url: "/livesim2/id3_4/testpic_2s/A48/40.m4s", wantedCode: http.StatusOK},
+		{desc: "bad interval", url: "/livesim2/id3_0/testpic_2s/Manifest.mpd", wantedCode: http.StatusBadRequest},
+		{desc: "text without id3", url: "/livesim2/id3text_hello/testpic_2s/Manifest.mpd", wantedCode: http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			resp, body := testFullRequest(t, ts, "GET", tc.url+"?nowMS=100000", nil)
+			require.Equal(t, tc.wantedCode, resp.StatusCode)
+			if tc.wantedCode != http.StatusOK {
+				return
+			}
+			require.Equal(t, tc.wantedID3, strings.Contains(string(body), id3.SchemeIDURI))
+			if tc.wantedInBody != "" {
+				require.Contains(t, string(body), tc.wantedInBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,542 @@
+// Copyright 2023, DASH-Industry Forum. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.md file.
+
+package app
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/Dash-Industry-Forum/livesim2/pkg/drm"
+	m "github.com/Eyevinn/dash-mpd/mpd"
+)
+
+const (
+	hlsVersion           = 7
+	hlsLowLatencyVersion = 9
+	// Parts are listed for segments in this many target durations from the live edge
+	hlsPartTargetDurations = 3
+	// Segments older than this many target durations from the end can be skipped
+	hlsSkipTargetDurations = 6
+	hlsPartHoldBackParts   = 3
+	hlsContentType         = "application/vnd.apple.mpegurl"
+	hlsAudioGroupID        = "audio"
+	hlsDateTimeLayout      = "2006-01-02T15:04:05.000Z"
+)
+
+// hlsMediaPlaylistName returns the name of the media playlist for a representation.
+// The name is relative to the multivariant playlist.
+func hlsMediaPlaylistName(mpdName, repID string) string {
+	return strings.TrimSuffix(mpdName, ".mpd") + "_" + repID + ".m3u8"
+}
+
+// parseHLSName returns the name of the corresponding MPD and the representation ID
+// for a playlist name. The representation ID is empty for a multivariant playlist.
+func (a *asset) parseHLSName(playlistName string) (mpdName, repID string, ok bool) {
+	base := strings.TrimSuffix(playlistName, ".m3u8")
+	if _, ok := a.MPDs[base+".mpd"]; ok {
+		return base + ".mpd", "", true
+	}
+	// Choose the longest MPD name, since MPD names may contain underscores
+	for name := range a.MPDs {
+		prefix := strings.TrimSuffix(name, ".mpd") + "_"
+		if strings.HasPrefix(base, prefix) && len(name) > len(mpdName) {
+			mpdName = name
+			repID = strings.TrimPrefix(base, prefix)
+		}
+	}
+	return mpdName, repID, mpdName != ""
+}
+
+// hlsMPD generates the live MPD from which the HLS playlists are derived.
+// The MPD always has a SegmentTimeline with $Number$, so that the segment list and
+// numbering are the same as for DASH. Segment URIs use $Time$ if the URL config
+// has segtimeline, since that is how segments are addressed.
+func hlsMPD(a *asset, mpdName string, cfg *ResponseConfig, drmCfg *drm.DrmConfig, nowMS int) (*m.MPD, error) {
+	if cfg.SegTimelineLossFlag {
+		return nil, fmt.Errorf("segtimelineloss is not supported for HLS")
+	}
+	hlsCfg := *cfg
+	hlsCfg.SegTimelineFlag = false
+	hlsCfg.SegTimelineNrFlag = true
+	hlsCfg.PatchTTL = 0
+	mpd, err := LiveMPD(a, mpdName, &hlsCfg, drmCfg, nowMS)
+	if err != nil {
+		return nil, err
+	}
+	if len(mpd.Periods) != 1 {
+		return nil, fmt.Errorf("multiple periods are not supported for HLS")
+	}
+	return mpd, nil
+}
+
+// LiveHLSMultivariant generates a multivariant playlist corresponding to the MPD mpdName.
+// Video representations are variant streams, while audio representations are renditions.
+// Text tracks are left out, since the fMP4 wvtt and stpp formats are not generally supported
+// by HLS players.
+func LiveHLSMultivariant(a *asset, mpdName string, cfg *ResponseConfig, drmCfg *drm.DrmConfig, nowMS int) (string, error) {
+	mpd, err := hlsMPD(a, mpdName, cfg, drmCfg, nowMS)
+	if err != nil {
+		return "", err
+	}
+	var videoReps, audioReps []*m.RepresentationType
+	var sb strings.Builder
+	sb.WriteString("#EXTM3U\n")
+	sb.WriteString(fmt.Sprintf("#EXT-X-VERSION:%d\n", hlsVersion))
+	sb.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	for _, as := range mpd.Periods[0].AdaptationSets {
+		for _, rep := range as.Representations {
+			uri := hlsMediaPlaylistName(mpdName, rep.Id)
+			switch as.ContentType {
+			case "video":
+				videoReps = append(videoReps, rep)
+			case "audio":
+				def := "NO"
+				if len(audioReps) == 0 {
+					def = "YES"
+				}
+				sb.WriteString(fmt.Sprintf("#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=%q,NAME=%q,%sDEFAULT=%s,AUTOSELECT=YES,URI=%q\n",
+					hlsAudioGroupID, hlsRenditionName(as, rep), hlsLanguageAttr(as), def, uri))
+				audioReps = append(audioReps, rep)
+			}
+		}
+	}
+	if len(videoReps) == 0 {
+		// Audio-only content, so the audio representations are the variant streams
+		for _, rep := range audioReps {
+			sb.WriteString(fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d,CODECS=%q\n", rep.Bandwidth, rep.GetCodecs()))
+			sb.WriteString(hlsMediaPlaylistName(mpdName, rep.Id) + "\n")
+		}
+		return sb.String(), nil
+	}
+	maxAudioBW := uint32(0)
+	audioCodecs := ""
+	for _, rep := range audioReps {
+		maxAudioBW = max(maxAudioBW, rep.Bandwidth)
+		if audioCodecs == "" {
+			audioCodecs = rep.GetCodecs()
+		}
+	}
+	for _, rep := range videoReps {
+		codecs := rep.GetCodecs()
+		if audioCodecs != "" {
+			codecs += "," + audioCodecs
+		}
+		sb.WriteString(fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d,CODECS=%q", rep.Bandwidth+maxAudioBW, codecs))
+		width, height := rep.Width, rep.Height
+		if width == 0 {
+			width, height = rep.Parent().Width, rep.Parent().Height
+		}
+		if width > 0 && height > 0 {
+			sb.WriteString(fmt.Sprintf(",RESOLUTION=%dx%d", width, height))
+		}
+		if len(audioReps) > 0 {
+			sb.WriteString(fmt.Sprintf(",AUDIO=%q", hlsAudioGroupID))
+		}
+		sb.WriteString("\n")
+		sb.WriteString(hlsMediaPlaylistName(mpdName, rep.Id) + "\n")
+	}
+	return sb.String(), nil
+}
+
+func hlsRenditionName(as *m.AdaptationSetType, rep *m.RepresentationType) string {
+	if as.Lang != "" {
+		return as.Lang + "-" + rep.Id
+	}
+	return rep.Id
+}
+
+func hlsLanguageAttr(as *m.AdaptationSetType) string {
+	if as.Lang == "" {
+		return ""
+	}
+	return fmt.Sprintf("LANGUAGE=%q,", as.Lang)
+}
+
+// hlsSegment is a segment entry in a media playlist.
+type hlsSegment struct {
+	nr   uint32
+	t    uint64
+	dur  uint64
+	uri  string
+	durS float64
+}
+
+// hlsMediaOptions are the query parameters of a media playlist request.
+type hlsMediaOptions struct {
+	skip bool // _HLS_skip=YES
+}
+
+// LiveHLSMedia generates a sliding-window media playlist for representation repID.
+// The segments are the same as in the SegmentTimeline of the live MPD.
+// After the stop time, the playlist ends with EXT-X-ENDLIST.
+//
+// In low-latency mode, the chunks of the segments are signalled as LL-HLS partial segments,
+// and segments are only listed with EXTINF when they are complete.
+func LiveHLSMedia(a *asset, mpdName, repID string, cfg *ResponseConfig, drmCfg *drm.DrmConfig,
+	nowMS int, opts hlsMediaOptions) (string, error) {
+	mpd, err := hlsMPD(a, mpdName, cfg, drmCfg, nowMS)
+	if err != nil {
+		return "", err
+	}
+	period := mpd.Periods[0]
+	var as *m.AdaptationSetType
+	var rep *m.RepresentationType
+	for _, pAS := range period.AdaptationSets {
+		for _, pRep := range pAS.Representations {
+			if pRep.Id == repID {
+				as, rep = pAS, pRep
+			}
+		}
+	}
+	if rep == nil {
+		return "", fmt.Errorf("representation %q: %w", repID, errNotFound)
+	}
+	if as.ContentType == "image" || as.ContentType == "text" {
+		return "", fmt.Errorf("%s representation %q not supported for HLS", as.ContentType, repID)
+	}
+	ast, err := time.Parse(time.RFC3339, string(mpd.AvailabilityStartTime))
+	if err != nil {
+		return "", fmt.Errorf("availabilityStartTime: %w", err)
+	}
+	baseURL := ""
+	if len(period.BaseURLs) > 0 {
+		baseURL = string(period.BaseURLs[0].Value)
+	}
+	st := as.SegmentTemplate
+	timescale := uint64(st.GetTimescale())
+	media := baseURL + hlsMediaTemplate(st, rep, cfg.SegTimelineFlag)
+	segs := hlsSegments(st, media)
+	nowT := uint64(max(nowMS-int(ast.UnixMilli()), 0)) * timescale / 1000
+	segs = hlsSlidingWindow(segs, nowT, uint64(*cfg.TimeShiftBufferDepthS)*timescale)
+	targetDur := hlsTargetDuration(a, segs)
+
+	lowLatency := !cfg.AvailabilityTimeCompleteFlag && (as.ContentType == "video" || as.ContentType == "audio")
+	var partial *hlsSegment // segment for which only some parts are available
+	var partDurs func(segDur uint64) []uint64
+	if lowLatency {
+		if n := len(segs); n > 0 && segs[n-1].t+segs[n-1].dur > nowT {
+			partial = &segs[n-1]
+			segs = segs[:n-1]
+		}
+		sampleDur := uint32(0)
+		if vodRep, ok := a.Reps[repID]; ok {
+			sampleDur = vodRep.sampleDur()
+		}
+		chunkDur := chunkDuration(a, cfg, int(timescale))
+		partDurs = func(segDur uint64) []uint64 {
+			return hlsPartDurations(sampleDur, segDur, chunkDur)
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString("#EXTM3U\n")
+	if lowLatency {
+		sb.WriteString(fmt.Sprintf("#EXT-X-VERSION:%d\n", hlsLowLatencyVersion))
+	} else {
+		sb.WriteString(fmt.Sprintf("#EXT-X-VERSION:%d\n", hlsVersion))
+	}
+	sb.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", targetDur))
+	var partTargetS float64
+	if lowLatency {
+		nominalSegDur := uint64(a.SegmentDurMS) * timescale / 1000
+		maxPartDur := uint64(chunkDuration(a, cfg, int(timescale)))
+		for _, d := range partDurs(nominalSegDur) {
+			maxPartDur = max(maxPartDur, d)
+		}
+		partTargetS = float64(maxPartDur) / float64(timescale)
+		sb.WriteString(fmt.Sprintf("#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,CAN-SKIP-UNTIL=%.1f,PART-HOLD-BACK=%.3f\n",
+			float64(hlsSkipTargetDurations*targetDur), hlsPartHoldBackParts*partTargetS))
+		sb.WriteString(fmt.Sprintf("#EXT-X-PART-INF:PART-TARGET=%.3f\n", partTargetS))
+	}
+	mediaSequence := uint32(0)
+	switch {
+	case len(segs) > 0:
+		mediaSequence = segs[0].nr
+	case partial != nil:
+		mediaSequence = partial.nr
+	}
+	sb.WriteString(fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d\n", mediaSequence))
+	sb.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	for _, key := range hlsKeys(as) {
+		sb.WriteString(key + "\n")
+	}
+	initURI := baseURL + replaceIdentifiers(rep, st.Initialization)
+	sb.WriteString(fmt.Sprintf("#EXT-X-MAP:URI=%q\n", initURI))
+	if lowLatency && opts.skip && len(segs) > 0 {
+		// Skip segments that are older than CAN-SKIP-UNTIL from the end of the playlist
+		last := segs[len(segs)-1]
+		skipDur := uint64(hlsSkipTargetDurations*targetDur) * timescale
+		nrSkipped := 0
+		for _, seg := range segs {
+			if seg.t+seg.dur+skipDur > last.t+last.dur {
+				break
+			}
+			nrSkipped++
+		}
+		if nrSkipped > 0 {
+			sb.WriteString(fmt.Sprintf("#EXT-X-SKIP:SKIPPED-SEGMENTS=%d\n", nrSkipped))
+			segs = segs[nrSkipped:]
+		}
+	}
+	firstT := uint64(0)
+	switch {
+	case len(segs) > 0:
+		firstT = segs[0].t
+	case partial != nil:
+		firstT = partial.t
+	}
+	if len(segs) > 0 || partial != nil {
+		startMS := ast.UnixMilli() + int64(firstT*1000/timescale)
+		pdt := time.UnixMilli(startMS).UTC().Format(hlsDateTimeLayout)
+		sb.WriteString(fmt.Sprintf("#EXT-X-PROGRAM-DATE-TIME:%s\n", pdt))
+	}
+	// Parts are listed for the segments in the last hlsPartTargetDurations target durations
+	partStartT := uint64(0)
+	if minT := uint64(hlsPartTargetDurations*targetDur) * timescale; nowT > minT {
+		partStartT = nowT - minT
+	}
+	independent := as.ContentType == "audio"
+	for _, seg := range segs {
+		if lowLatency && seg.t+seg.dur >= partStartT {
+			writeHLSParts(&sb, seg, partDurs(seg.dur), timescale, independent, seg.dur)
+		}
+		sb.WriteString(fmt.Sprintf("#EXTINF:%.3f,\n", seg.durS))
+		sb.WriteString(seg.uri + "\n")
+	}
+	if mpd.GetType() == m.STATIC_TYPE {
+		sb.WriteString("#EXT-X-ENDLIST\n")
+		return sb.String(), nil
+	}
+	if lowLatency {
+		// The preload hint is the next part of the partial segment, or the first part of next segment
+		var hintURI string
+		if partial != nil {
+			durs := partDurs(partial.dur)
+			nrParts := writeHLSParts(&sb, *partial, durs, timescale, independent, nowT-partial.t)
+			if nrParts < len(durs) {
+				hintURI = hlsPartURI(partial.uri, nrParts)
+			}
+		}
+		if hintURI == "" {
+			var next hlsSegment
+			switch {
+			case partial != nil:
+				next = hlsSegment{nr: partial.nr + 1, t: partial.t + partial.dur}
+			case len(segs) > 0:
+				next = hlsSegment{nr: segs[len(segs)-1].nr + 1, t: segs[len(segs)-1].t + segs[len(segs)-1].dur}
+			}
+			if len(segs) > 0 || partial != nil {
+				hintURI = hlsPartURI(replaceTimeAndNr(media, next.t, next.nr), 0)
+			}
+		}
+		if hintURI != "" {
+			sb.WriteString(fmt.Sprintf("#EXT-X-PRELOAD-HINT:TYPE=PART,URI=%q\n", hintURI))
+		}
+	}
+	return sb.String(), nil
+}
+
+// writeHLSParts writes EXT-X-PART tags for the parts of seg that end no later than untilT
+// relative to the segment start. Returns the number of parts written.
+func writeHLSParts(sb *strings.Builder, seg hlsSegment, partDurs []uint64, timescale uint64, independent bool, untilT uint64) int {
+	end := uint64(0)
+	for i, d := range partDurs {
+		end += d
+		if end > untilT {
+			return i
+		}
+		sb.WriteString(fmt.Sprintf("#EXT-X-PART:DURATION=%.5f,URI=%q", float64(d)/float64(timescale), hlsPartURI(seg.uri, i)))
+		if i == 0 || independent {
+			sb.WriteString(",INDEPENDENT=YES")
+		}
+		sb.WriteString("\n")
+	}
+	return len(partDurs)
+}
+
+// hlsPartURI returns the URI of part partNr of the segment with URI segURI.
+func hlsPartURI(segURI string, partNr int) string {
+	return fmt.Sprintf("%s?part=%d", segURI, partNr)
+}
+
+// hlsPartDurations returns the durations of the parts of a segment with duration segDur.
+// The samples are assumed to have constant duration sampleDur, so that the parts
+// are the same as the chunks generated by chunkSegment. If sampleDur is unknown (0),
+// the parts have the nominal chunk duration.
+func hlsPartDurations(sampleDur uint32, segDur uint64, chunkDur int) []uint64 {
+	if sampleDur == 0 {
+		sampleDur = uint32(chunkDur)
+	}
+	nrSamples := segDur / uint64(sampleDur)
+	sampleDurs := make([]uint32, 0, nrSamples+1)
+	for i := uint64(0); i < nrSamples; i++ {
+		sampleDurs = append(sampleDurs, sampleDur)
+	}
+	if rest := segDur - nrSamples*uint64(sampleDur); rest > 0 {
+		sampleDurs = append(sampleDurs, uint32(rest))
+	}
+	durs := make([]uint64, 0, 8)
+	sampleNr := 0
+	for _, count := range chunkSampleCounts(sampleDurs, chunkDur) {
+		d := uint64(0)
+		for _, sd := range sampleDurs[sampleNr : sampleNr+count] {
+			d += uint64(sd)
+		}
+		durs = append(durs, d)
+		sampleNr += count
+	}
+	return durs
+}
+
+// hlsPlaylistReadyMS returns the time when segment msn of representation repID is complete,
+// or when part partNr of the segment is available if partNr >= 0.
+// A part number beyond the last part of the segment refers to the first part of the next segment.
+func hlsPlaylistReadyMS(a *asset, repID string, cfg *ResponseConfig, msn, partNr, nowMS int) int {
+	rep, ok := a.Reps[repID]
+	if !ok { // Generated representation such as timesubs
+		rep = a.refRep
+	}
+	nrCfg := cfg
+	if cfg.ContUpdateFlag {
+		nrCfg = contUpdateConfig(a, cfg, nowMS)
+	}
+	nrAfterStart := msn - nrCfg.getStartNr()
+	if nrAfterStart < 0 {
+		return 0
+	}
+	timescale := rep.MediaTimescale
+	wrapLen := len(rep.Segments)
+	nrWraps := nrAfterStart / wrapLen
+	seg := rep.Segments[nrAfterStart-nrWraps*wrapLen]
+	segStart := uint64(nrWraps*a.LoopDurMS*timescale/1000) + seg.StartTime
+	end := segStart + seg.dur()
+	if partNr >= 0 {
+		partDurs := hlsPartDurations(rep.sampleDur(), seg.dur(), chunkDuration(a, nrCfg, timescale))
+		if partNr >= len(partDurs) {
+			return hlsPlaylistReadyMS(a, repID, cfg, msn+1, 0, nowMS)
+		}
+		end = segStart
+		for _, d := range partDurs[:partNr+1] {
+			end += d
+		}
+	}
+	endMS := (end*1000 + uint64(timescale) - 1) / uint64(timescale) // Round up
+	return nrCfg.StartTimeS*1000 + int(endMS)
+}
+
+// hlsMediaTemplate returns the media template with the representation identifiers replaced.
+func hlsMediaTemplate(st *m.SegmentTemplateType, rep *m.RepresentationType, useTime bool) string {
+	media := replaceIdentifiers(rep, st.Media)
+	if useTime {
+		media = strings.Replace(media, "$Number$", "$Time$", -1)
+	}
+	return media
+}
+
+// hlsSegments returns the segments listed in the SegmentTimeline of the SegmentTemplate.
+// The segments are numbered from the startNumber of the SegmentTemplate.
+func hlsSegments(st *m.SegmentTemplateType, media string) []hlsSegment {
+	if st.SegmentTimeline == nil {
+		return nil
+	}
+	timescale := float64(st.GetTimescale())
+	nr := uint32(0)
+	if st.StartNumber != nil {
+		nr = *st.StartNumber
+	}
+	var t uint64
+	segs := make([]hlsSegment, 0, 32)
+	for _, s := range st.SegmentTimeline.S {
+		if s.T != nil {
+			t = *s.T
+		}
+		for i := 0; i <= s.R; i++ {
+			segs = append(segs, hlsSegment{
+				nr:   nr,
+				t:    t,
+				dur:  s.D,
+				uri:  replaceTimeAndNr(media, t, nr),
+				durS: float64(s.D) / timescale,
+			})
+			t += s.D
+			nr++
+		}
+	}
+	return segs
+}
+
+// hlsSlidingWindow returns the segments that end inside the time-shift buffer of duration windowDur.
+// The window ends at nowT, or at the end of the last segment if that is earlier (after the stop time).
+func hlsSlidingWindow(segs []hlsSegment, nowT, windowDur uint64) []hlsSegment {
+	if len(segs) == 0 {
+		return segs
+	}
+	last := segs[len(segs)-1]
+	windowEnd := min(last.t+last.dur, nowT)
+	if windowEnd <= windowDur {
+		return segs
+	}
+	windowStart := windowEnd - windowDur
+	for i, seg := range segs {
+		if seg.t+seg.dur > windowStart {
+			return segs[i:]
+		}
+	}
+	return nil
+}
+
+// hlsTargetDuration returns the target duration in whole seconds.
+// It is at least the nominal segment duration, so that it does not change over time.
+func hlsTargetDuration(a *asset, segs []hlsSegment) int {
+	maxDurS := float64(a.SegmentDurMS) / 1000
+	for _, seg := range segs {
+		maxDurS = max(maxDurS, seg.durS)
+	}
+	return int(math.Ceil(maxDurS))
+}
+
+// hlsKeys returns EXT-X-KEY tags corresponding to the ContentProtection descriptors.
+// cbcs maps to METHOD=SAMPLE-AES and cenc to METHOD=SAMPLE-AES-CTR.
+func hlsKeys(as *m.AdaptationSetType) []string {
+	var method, keyID string
+	for _, cp := range as.ContentProtections {
+		if cp.SchemeIdUri == "urn:mpeg:dash:mp4protection:2011" {
+			switch cp.Value {
+			case "cbcs":
+				method = "SAMPLE-AES"
+			default:
+				method = "SAMPLE-AES-CTR"
+			}
+			keyID = "0x" + strings.ReplaceAll(cp.DefaultKID, "-", "")
+		}
+	}
+	if method == "" {
+		return nil
+	}
+	keys := make([]string, 0, len(as.ContentProtections))
+	for _, cp := range as.ContentProtections {
+		var uri, keyFormat string
+		switch {
+		case cp.SchemeIdUri == "urn:mpeg:dash:mp4protection:2011":
+			continue
+		case cp.SchemeIdUri == m.DRM_CLEAR_KEY_DASHIF:
+			if cp.LaURL == nil {
+				continue
+			}
+			uri = string(cp.LaURL.Value)
+			keyFormat = "org.w3.clearkey"
+		case cp.Pssh != nil:
+			uri = "data:text/plain;base64," + cp.Pssh.Value
+			keyFormat = string(cp.SchemeIdUri)
+		default:
+			continue
+		}
+		keys = append(keys, fmt.Sprintf("#EXT-X-KEY:METHOD=%s,URI=%q,KEYID=%s,KEYFORMAT=%q,KEYFORMATVERSIONS=\"1\"",
+			method, uri, keyID, keyFormat))
+	}
+	return keys
+}
@@ -0,0 +1,258 @@
+// Copyright 2023, DASH-Industry Forum. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.md file.
+
+package app
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHLSName(t *testing.T) {
+	vodFS := os.DirFS("testdata/assets")
+	am := newAssetMgr(vodFS, "", false)
+	err := am.discoverAssets(slog.Default())
+	require.NoError(t, err)
+	asset, ok := am.findAsset("testpic_2s")
+	require.True(t, ok)
+
+	cases := []struct {
+		playlist    string
+		wantedOK    bool
+		wantedMPD   string
+		wantedRepID string
+	}{
+		{playlist: "Manifest.m3u8", wantedOK: true, wantedMPD: "Manifest.mpd"},
+		{playlist: "Manifest_thumbs.m3u8", wantedOK: true, wantedMPD: "Manifest_thumbs.mpd"},
+		{playlist: "Manifest_V300.m3u8", wantedOK: true, wantedMPD: "Manifest.mpd", wantedRepID: "V300"},
+		{playlist: "Manifest_thumbs_V300.m3u8", wantedOK: true, wantedMPD: "Manifest_thumbs.mpd", wantedRepID: "V300"},
+		{playlist: "Other.m3u8", wantedOK: false},
+	}
+	for _, tc := range cases {
+		mpdName, repID, ok := asset.parseHLSName(tc.playlist)
+		require.Equal(t, tc.wantedOK, ok, tc.playlist)
+		if !ok {
+			continue
+		}
+		require.Equal(t, tc.wantedMPD, mpdName)
+		require.Equal(t, tc.wantedRepID, repID)
+		if repID != "" {
+			require.Equal(t, tc.playlist, hlsMediaPlaylistName(mpdName, repID))
+		}
+	}
+}
+
+func TestLiveHLS(t *testing.T) {
+	vodFS := os.DirFS("testdata/assets")
+	am := newAssetMgr(vodFS, "", false)
+	err := am.discoverAssets(slog.Default())
+	require.NoError(t, err)
+	asset, ok := am.findAsset("testpic_2s")
+	require.True(t, ok)
+
+	cases := []struct {
+		desc                string
+		params              string
+		nowMS               int
+		wantedInMultiVar    []string
+		wantedNotInMultiVar []string
+		repID               string
+		wantedMediaErr      string
+		wantedInMedia       []string
+		wantedNotInMedia    []string
+		wantedMediaSequence string
+	}{
+		{
+			desc:   "sliding window",
+			params: "tsbd_20/",
+			nowMS:  100_000,
+			wantedInMultiVar: []string{
+				`#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="audio",NAME="en-A48",LANGUAGE="en",DEFAULT=YES,AUTOSELECT=YES,URI="Manifest_A48.m3u8"`,
+				`AUDIO="audio"`,
+				"Manifest_V300.m3u8\n",
+			},
+			repID:               "V300",
+			wantedInMedia:       []string{"#EXT-X-TARGETDURATION:2\n", "#EXT-X-MAP:URI=\"V300/init.mp4\"\n", "#EXTINF:2.000,\nV300/49.m4s\n"},
+			wantedNotInMedia:    []string{"V300/39.m4s", "V300/50.m4s", "#EXT-X-ENDLIST"},
+			wantedMediaSequence: "40",
+		},
+		{
+			desc:                "start number",
+			params:              "tsbd_20/snr_10/",
+			nowMS:               100_000,
+			repID:               "A48",
+			wantedInMedia:       []string{"A48/59.m4s\n"},
+			wantedNotInMedia:    []string{"A48/60.m4s"},
+			wantedMediaSequence: "50",
+		},
+		{
+			desc:                "segment timeline with time",
+			params:              "tsbd_20/segtimeline_1/",
+			nowMS:               100_000,
+			repID:               "V300",
+			wantedInMedia:       []string{"V300/8820000.m4s\n"},
+			wantedMediaSequence: "40",
+		},
+		{
+			desc:                "after stop",
+			params:              "tsbd_20/stop_80/",
+			nowMS:               100_000,
+			repID:               "V300",
+			wantedInMedia:       []string{"V300/39.m4s\n#EXT-X-ENDLIST\n"},
+			wantedNotInMedia:    []string{"V300/40.m4s"},
+			wantedMediaSequence: "30",
+		},
+		{
+			desc:   "clear key drm",
+			params: "eccp_cbcs/",
+			nowMS:  100_000,
+			repID:  "V300",
+			wantedInMedia: []string{
+				`#EXT-X-KEY:METHOD=SAMPLE-AES,URI="`,
+				`KEYFORMAT="org.w3.clearkey"`,
+			},
+		},
+		{
+			desc:   "timesubs",
+			params: "tsbd_20/timesubswvtt_en/",
+			nowMS:  100_000,
+			wantedInMultiVar: []string{
+				"Manifest_V300.m3u8\n",
+			},
+			wantedNotInMultiVar: []string{"TYPE=SUBTITLES", "SUBTITLES=", "timewvtt-en"},
+			repID:               "timewvtt-en",
+			wantedMediaErr:      `text representation "timewvtt-en" not supported for HLS`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			cfg, err := processURLCfg("/livesim2/"+tc.params+"testpic_2s/Manifest.m3u8", tc.nowMS)
+			require.NoError(t, err)
+			multiVar, err := LiveHLSMultivariant(asset, "Manifest.mpd", cfg, nil, tc.nowMS)
+			require.NoError(t, err)
+			require.True(t, strings.HasPrefix(multiVar, "#EXTM3U\n"))
+			for _, wanted := range tc.wantedInMultiVar {
+				require.Contains(t, multiVar, wanted)
+			}
+			for _, notWanted := range tc.wantedNotInMultiVar {
+				require.NotContains(t, multiVar, notWanted)
+			}
+			media, err := LiveHLSMedia(asset, "Manifest.mpd", tc.repID, cfg, nil, tc.nowMS, hlsMediaOptions{})
+			if tc.wantedMediaErr != "" {
+				require.EqualError(t, err, tc.wantedMediaErr)
+				return
+			}
+			require.NoError(t, err)
+			for _, wanted := range tc.wantedInMedia {
+				require.Contains(t, media, wanted)
+			}
+			for _, notWanted := range tc.wantedNotInMedia {
+				require.NotContains(t, media, notWanted)
+			}
+			if tc.wantedMediaSequence != "" {
+				require.Contains(t, media, "#EXT-X-MEDIA-SEQUENCE:"+tc.wantedMediaSequence+"\n")
+			}
+		})
+	}
+
+	cfg, err := processURLCfg("/livesim2/testpic_2s/Manifest.m3u8", 100_000)
+	require.NoError(t, err)
+	_, err = LiveHLSMedia(asset, "Manifest.mpd", "V999", cfg, nil, 100_000, hlsMediaOptions{})
+	require.ErrorIs(t, err, errNotFound)
+	cfg, err = processURLCfg("/livesim2/periods_60/testpic_2s/Manifest.m3u8", 100_000)
+	require.NoError(t, err)
+	_, err = LiveHLSMultivariant(asset, "Manifest.mpd", cfg, nil, 100_000)
+	require.Error(t, err)
+}
+
+func TestLowLatencyHLS(t *testing.T) {
+	vodFS := os.DirFS("testdata/assets")
+	am := newAssetMgr(vodFS, "", false)
+	err := am.discoverAssets(slog.Default())
+	require.NoError(t, err)
+	asset, ok := am.findAsset("testpic_2s")
+	require.True(t, ok)
+
+	nowMS := 100_700
+	cfg, err := processURLCfg("/livesim2/tsbd_20/chunkdur_0.5/testpic_2s/Manifest.m3u8", nowMS)
+	require.NoError(t, err)
+
+	cases := []struct {
+		desc      string
+		opts      hlsMediaOptions
+		wanted    []string
+		notWanted []string
+	}{
+		{
+			desc: "full playlist",
+			wanted: []string{
+				"#EXT-X-VERSION:9\n",
+				"#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,CAN-SKIP-UNTIL=12.0,PART-HOLD-BACK=1.500\n",
+				"#EXT-X-PART-INF:PART-TARGET=0.500\n",
+				"#EXT-X-PART:DURATION=0.50000,URI=\"V300/48.m4s?part=3\"\n",
+				"#EXTINF:2.000,\nV300/49.m4s\n",
+				"#EXT-X-PART:DURATION=0.50000,URI=\"V300/50.m4s?part=0\",INDEPENDENT=YES\n",
+				"#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"V300/50.m4s?part=1\"\n",
+				"V300/40.m4s\n",
+			},
+			notWanted: []string{
+				"V300/45.m4s?part=0",
+				"#EXT-X-PART:DURATION=0.50000,URI=\"V300/50.m4s?part=1\"",
+				"V300/50.m4s\n",
+				"#EXT-X-SKIP",
+			},
+		},
+		{
+			desc: "delta update",
+			opts: hlsMediaOptions{skip: true},
+			wanted: []string{
+				"#EXT-X-SKIP:SKIPPED-SEGMENTS=",
+				"V300/44.m4s\n",
+				"#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"V300/50.m4s?part=1\"\n",
+			},
+			notWanted: []string{
+				"V300/43.m4s\n",
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			media, err := LiveHLSMedia(asset, "Manifest.mpd", "V300", cfg, nil, nowMS, tc.opts)
+			require.NoError(t, err)
+			for _, wanted := range tc.wanted {
+				require.Contains(t, media, wanted)
+			}
+			for _, notWanted := range tc.notWanted {
+				require.NotContains(t, media, notWanted)
+			}
+		})
+	}
+
+	require.Equal(t, 101_000, hlsPlaylistReadyMS(asset, "V300", cfg, 50, 1, nowMS))
+	require.Equal(t, 102_000, hlsPlaylistReadyMS(asset, "V300", cfg, 50, -1, nowMS))
+	require.Equal(t, 102_500, hlsPlaylistReadyMS(asset, "V300", cfg, 50, 4, nowMS))
+}
+
+func TestHLSPartDurations(t *testing.T) {
+	cases := []struct {
+		desc      string
+		sampleDur uint32
+		segDur    uint64
+		chunkDur  int
+		wanted    []uint64
+	}{
+		{desc: "video", sampleDur: 3000, segDur: 180_000, chunkDur: 45_000, wanted: []uint64{45_000, 45_000, 45_000, 45_000}},
+		{desc: "unknown sample duration", sampleDur: 0, segDur: 180_000, chunkDur: 45_000, wanted: []uint64{45_000, 45_000, 45_000, 45_000}},
+		{desc: "audio", sampleDur: 1024, segDur: 96_000, chunkDur: 24_000, wanted: []uint64{23_552, 24_576, 23_552, 24_320}},
+		{desc: "single frame", sampleDur: 3000, segDur: 12_000, chunkDur: 3000, wanted: []uint64{3000, 3000, 3000, 3000}},
+	}
+	for _, tc := range cases {
+		got := hlsPartDurations(tc.sampleDur, tc.segDur, tc.chunkDur)
+		require.Equal(t, tc.wanted, got, tc.desc)
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/Dash-Industry-Forum/livesim2/pkg/drm"
+	"github.com/Dash-Industry-Forum/livesim2/pkg/id3"
 	"github.com/Dash-Industry-Forum/livesim2/pkg/scte35"
 	m "github.com/Eyevinn/dash-mpd/mpd"
 )
@@ -58,6 +59,9 @@ func LiveMPD(a *asset, mpdName string, cfg *ResponseConfig, drmCfg *drm.DrmConfi
 	if err != nil {
 		return nil, err
 	}
+	if err := cfg.setAtoFromChunkDur(a.SegmentDurMS); err != nil {
+		return nil, err
+	}
 	mpd.Type = Ptr("dynamic")
 	mpd.MediaPresentationDuration = nil
 	mpd.AvailabilityStartTime = m.ConvertToDateTime(float64(cfg.StartTimeS))
@@ -91,6 +95,10 @@ func LiveMPD(a *asset, mpdName string, cfg *ResponseConfig, drmCfg *drm.DrmConfi
 		}
 		mpd.Location = []m.AnyURI{m.AnyURI(strBuf.String())}
 	}
+	if cfg.ContUpdateFlag {
+		cfg = contUpdateConfig(a, cfg, nowMS)
+		mpd.AvailabilityStartTime = m.ConvertToDateTime(float64(cfg.StartTimeS))
+	}
 
 	if cfg.getAvailabilityTimeOffsetS() > 0 {
 		if !cfg.AvailabilityTimeCompleteFlag {
@@ -114,16 +122,41 @@ func LiveMPD(a *asset, mpdName string, cfg *ResponseConfig, drmCfg *drm.DrmConfi
 		}
 	}
 
+	if cfg.Tfdt32Flag {
+		err = checkTfdt32(a, cfg, endTimeMS)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.ModuloPeriodMinutes != nil && !afterStop {
+		// The end of the session is known in advance
+		mpd.MediaPresentationDuration = m.Seconds2DurPtr(*cfg.StopTimeS - cfg.StartTimeS)
+	}
+
 	wTimes := calcWrapTimes(a, cfg, endTimeMS, *mpd.TimeShiftBufferDepth)
 
 	period := mpd.Periods[0]
 	period.Duration = nil
 	period.Id = "P0"
 	period.Start = Ptr(m.Duration(0))
-	for bNr := 0; bNr < len(cfg.Traffic); bNr++ {
+	for bNr := 0; bNr < cfg.nrBaseURLs(); bNr++ {
 		b := m.NewBaseURL(baseURL(bNr))
+		if len(cfg.Steering) > 0 {
+			b.ServiceLocation = pathwayID(bNr)
+		}
 		period.BaseURLs = append(period.BaseURLs, b)
 	}
+	if cfg.ContUpdateFlag {
+		contURL := contBaseURL(cfg)
+		if len(period.BaseURLs) == 0 {
+			period.BaseURLs = append(period.BaseURLs, m.NewBaseURL(contURL))
+		} else {
+			for _, b := range period.BaseURLs {
+				b.Value = m.AnyURI(contURL) + b.Value
+			}
+		}
+	}
 
 	fillContentTypes(a.AssetPath, period)
 
@@ -213,7 +246,7 @@ func LiveMPD(a *asset, mpdName string, cfg *ResponseConfig, drmCfg *drm.DrmConfi
 				}
 			}
 		}
-		if as.ContentType == "video" && cfg.SCTE35PerMinute != nil {
+		if as.ContentType == "video" && cfg.scte35Inband() {
 			// Add SCTE35 signaling
 			as.InbandEventStreams = append(as.InbandEventStreams,
 				&m.EventStreamType{
@@ -221,10 +254,34 @@ func LiveMPD(a *asset, mpdName string, cfg *ResponseConfig, drmCfg *drm.DrmConfi
 					Value:       "",
 				})
 		}
+		if as.ContentType == "video" && cfg.ID3IntervalS != nil {
+			as.InbandEventStreams = append(as.InbandEventStreams,
+				&m.EventStreamType{
+					SchemeIdUri: id3.SchemeIDURI,
+					Value:       "",
+				})
+		}
+		if as.ContentType == "video" && cfg.callbackInband() {
+			as.InbandEventStreams = append(as.InbandEventStreams,
+				&m.EventStreamType{
+					SchemeIdUri: callbackSchemeIDURI,
+					Value:       callbackValue,
+				})
+		}
+		if (as.ContentType == "video" || as.ContentType == "audio") && cfg.MPDExpiryLeadS != nil {
+			as.InbandEventStreams = append(as.InbandEventStreams,
+				&m.EventStreamType{
+					SchemeIdUri: mpdEventSchemeIDURI,
+					Value:       mpdValidityExpiration,
+				})
+		}
 		atoMS, err := setOffsetInAdaptationSet(cfg, as)
 		if err != nil {
 			return nil, err
 		}
+		if (as.ContentType == "video" || as.ContentType == "audio") && cfg.PrftDelayMS != nil {
+			as.ProducerReferenceTimes = createProducerReferenceTimes(cfg)
+		}
 		var se segEntries
 		if asIdx == 0 {
 			// Assume that first representation is as good as any, so can be reference
@@ -241,6 +298,10 @@ func LiveMPD(a *asset, mpdName string, cfg *ResponseConfig, drmCfg *drm.DrmConfi
 			}
 		}
 
+		if cfg.SegTimelineLossFlag && (as.ContentType == "video" || as.ContentType == "audio") {
+			se.entries = dropLostSegments(a, se.entries, se.mediaTimescale)
+		}
+
 		templateType := cfg.liveMPDType()
 		if as.ContentType == "image" {
 			templateType = segmentNumber
@@ -255,7 +316,7 @@ func LiveMPD(a *asset, mpdName string, cfg *ResponseConfig, drmCfg *drm.DrmConfi
 				mpd.PublishTime = m.ConvertToDateTime(calcPublishTime(cfg, se.lsi))
 			}
 		case timeLineNumber:
-			err := adjustAdaptationSetForTimelineNr(se, as)
+			err := adjustAdaptationSetForTimelineNr(cfg, se, as)
 			if err != nil {
 				return nil, fmt.Errorf("adjustASForTimelineNr: %w", err)
 			}
@@ -284,7 +345,19 @@ func LiveMPD(a *asset, mpdName string, cfg *ResponseConfig, drmCfg *drm.DrmConfi
 			return nil, fmt.Errorf("addTimeSubs wvtt: %w", err)
 		}
 	}
-	if cfg.PeriodsPerHour == nil {
+	periodOffsetS := cfg.getPeriodOffsetS()
+	if periodOffsetS*1000%a.SegmentDurMS != 0 {
+		return nil, fmt.Errorf("period offset %ds not a multiple of segment duration %dms", periodOffsetS, a.SegmentDurMS)
+	}
+	if cfg.PeriodsPerHour == nil && len(cfg.PeriodDurations) == 0 && !cfg.InsertAdFlag {
+		if periodOffsetS > 0 {
+			p, err := createPeriod(period, cfg, period.Id, periodOffsetS, openEndS)
+			if err != nil {
+				return nil, fmt.Errorf("createPeriod: %w", err)
+			}
+			mpd.Periods = nil
+			mpd.AppendPeriod(p)
+		}
 		if afterStop {
 			mpdDurS := *cfg.StopTimeS - cfg.StartTimeS
 			makeMPDStatic(mpd, mpdDurS)
@@ -294,10 +367,17 @@ func LiveMPD(a *asset, mpdName string, cfg *ResponseConfig, drmCfg *drm.DrmConfi
 		return mpd, nil
 	}
 
-	// Split into multiple periods
-	err = splitPeriod(mpd, a, cfg, wTimes)
-	if err != nil {
-		return nil, fmt.Errorf("splitPeriods: %w", err)
+	if cfg.InsertAdFlag {
+		err = insertAdPeriods(mpd, a, cfg, drmCfg, wTimes)
+		if err != nil {
+			return nil, fmt.Errorf("insertAdPeriods: %w", err)
+		}
+	} else {
+		// Split into multiple periods
+		err = splitPeriod(mpd, a, cfg, wTimes)
+		if err != nil {
+			return nil, fmt.Errorf("splitPeriods: %w", err)
+		}
 	}
 
 	if cfg.liveMPDType() == segmentNumber {
@@ -317,6 +397,59 @@ func LiveMPD(a *asset, mpdName string, cfg *ResponseConfig, drmCfg *drm.DrmConfi
 	return mpd, nil
 }
 
+// contUpdateConfig returns a copy of cfg with availabilityStartTime and startNumber moved forward.
+// The availabilityStartTime follows the start of the time-shift buffer in steps which are
+// multiples of both a second and the segment duration, so that the segment numbering is continuous.
+func contUpdateConfig(a *asset, cfg *ResponseConfig, nowMS int) *ResponseConfig {
+	contCfg := *cfg
+	stepMS := lcm(a.SegmentDurMS, 1000)
+	tsbdMS := *cfg.TimeShiftBufferDepthS * 1000
+	nrSteps := max(nowMS-tsbdMS-cfg.StartTimeS*1000, 0) / stepMS
+	contCfg.StartTimeS = cfg.StartTimeS + nrSteps*stepMS/1000
+	contCfg.StartNr = Ptr(cfg.getStartNr() + nrSteps*stepMS/a.SegmentDurMS)
+	return &contCfg
+}
+
+// contBaseURL returns a relative BaseURL which sets the moved availabilityStartTime and
+// startNumber for the segment requests, since they are not known from the MPD URL.
+// Any start time or startNumber in the MPD URL is replaced.
+func contBaseURL(cfg *ResponseConfig) string {
+	nrLevelsUp := len(cfg.URLParts) - 3 // Up to the level after /livesim2
+	parts := make([]string, 0, len(cfg.URLParts))
+	for _, part := range cfg.URLParts[2:cfg.URLContentIdx] {
+		key, _, _ := strings.Cut(part, "_")
+		switch key {
+		case "start", "ast", "startrel", "snr":
+			continue
+		}
+		parts = append(parts, part)
+	}
+	parts = append(parts, fmt.Sprintf("start_%d", cfg.StartTimeS), fmt.Sprintf("snr_%d", cfg.getStartNr()))
+	parts = append(parts, cfg.URLParts[cfg.URLContentIdx:len(cfg.URLParts)-1]...)
+	return strings.Repeat("../", nrLevelsUp) + strings.Join(parts, "/") + "/"
+}
+
+// lcm returns the least common multiple of two positive integers.
+func lcm(a, b int) int {
+	x, y := a, b
+	for y != 0 {
+		x, y = y, x%y
+	}
+	return a / x * b
+}
+
+// checkTfdt32 checks that all media decode times up to nowMS fit in 32-bit tfdt boxes.
+func checkTfdt32(a *asset, cfg *ResponseConfig, nowMS int) error {
+	for _, rep := range a.Reps {
+		decodeTime := uint64(max(nowMS-cfg.StartTimeS*1000, 0)) * uint64(rep.MediaTimescale) / 1000
+		if decodeTime > math.MaxUint32 {
+			return fmt.Errorf("tfdt_32: decode time %d of rep %s does not fit in 32 bits. Use a later availabilityStartTime",
+				decodeTime, rep.ID)
+		}
+	}
+	return nil
+}
+
 // lastPeriodStartTime returns the absolute startTime of the last Period.
 func lastPeriodStartTime(mpd *m.MPD) (m.DateTime, error) {
 	lastPeriod := mpd.Periods[len(mpd.Periods)-1]
@@ -353,55 +486,46 @@ func makeMPDStatic(mpd *m.MPD, mpdDurS int) {
 }
 
 // splitPeriod splits the single-period MPD into multiple periods given cfg.PeriodsPerHour
+// or cycling through cfg.PeriodDurations.
+// The periods are numbered and cover the time from wTimes.startTimeMS to wTimes.nowMS. Period
 // continuity is signalled if configured.
 func splitPeriod(mpd *m.MPD, a *asset, cfg *ResponseConfig, wTimes wrapTimes) error {
 	if len(mpd.Periods) != 1 {
 		return fmt.Errorf("not exactly one period in the MPD")
 	}
-	if cfg.PeriodsPerHour == nil {
+	periodDurs := cfg.PeriodDurations
+	if cfg.PeriodsPerHour != nil {
+		periodDurs = []int{3600 / *cfg.PeriodsPerHour}
+	}
+	if len(periodDurs) == 0 {
 		return nil
 	}
-	periodDur := 3600 / *cfg.PeriodsPerHour
-	if periodDur*1000%a.SegmentDurMS != 0 {
-		return fmt.Errorf("period duration %ds not a multiple of segment duration %dms", periodDur, a.SegmentDurMS)
+	for _, periodDur := range periodDurs {
+		if periodDur*1000%a.SegmentDurMS != 0 {
+			return fmt.Errorf("period duration %ds not a multiple of segment duration %dms", periodDur, a.SegmentDurMS)
+		}
 	}
-
-	startPeriodNr := wTimes.startTimeMS / (periodDur * 1000)
-	endPeriodNr := wTimes.nowMS / (periodDur * 1000)
+	astMS := cfg.StartTimeS * 1000
+	itvls := multiPeriodItvls(periodDurs, cfg.getPeriodOffsetS(), wTimes.startTimeMS-astMS, wTimes.nowMS-astMS)
 	inPeriod := mpd.Periods[0]
-	nrPeriods := endPeriodNr - startPeriodNr + 1
-	periods := make([]*m.Period, 0, nrPeriods)
-	for pNr := startPeriodNr; pNr <= endPeriodNr; pNr++ {
-		p := inPeriod.Clone()
-		p.Id = fmt.Sprintf("P%d", pNr)
-		p.Start = m.Seconds2DurPtr(pNr * periodDur)
-		for aNr, as := range p.AdaptationSets {
-			inAS := inPeriod.AdaptationSets[aNr]
-			timeScale := int(as.SegmentTemplate.GetTimescale())
-			pto := Ptr(uint64(pNr * periodDur * timeScale))
-			templateType := cfg.liveMPDType()
-			if as.ContentType == "image" {
-				templateType = segmentNumber
-			}
-			switch templateType {
-			case segmentNumber:
-				as.SegmentTemplate.PresentationTimeOffset = pto
-				segDur := int(*as.SegmentTemplate.Duration)
-				startNr := uint32(pNr * periodDur * timeScale / segDur)
-				as.SegmentTemplate.StartNumber = Ptr(startNr)
-			case timeLineTime:
-				as.SegmentTemplate.PresentationTimeOffset = pto
-				inS := inAS.SegmentTemplate.SegmentTimeline.S
-				periodStart, periodEnd := uint64(pNr*periodDur), uint64((pNr+1)*periodDur)
-				as.SegmentTemplate.SegmentTimeline.S, _ = reduceS(inS, nil, timeScale, periodStart, periodEnd)
-			case timeLineNumber:
-				as.SegmentTemplate.PresentationTimeOffset = pto
-				inS := inAS.SegmentTemplate.SegmentTimeline.S
-				startNr := inAS.SegmentTemplate.StartNumber
-				periodStart, periodEnd := uint64(pNr*periodDur), uint64((pNr+1)*periodDur)
-				as.SegmentTemplate.SegmentTimeline.S, as.SegmentTemplate.StartNumber = reduceS(inS, startNr, timeScale, periodStart, periodEnd)
-			default:
-				return fmt.Errorf("unknown mpd type")
+	periods := make([]*m.Period, 0, len(itvls))
+	for _, itvl := range itvls {
+		pNr, periodStart, periodEnd := itvl.nr, itvl.startS, itvl.endS
+		isEtp := isEtpPeriod(cfg, pNr)
+		if isEtp {
+			periodEnd = periodStart + *cfg.EtpDuration
+		}
+		p, err := createPeriod(inPeriod, cfg, itvl.id, periodStart, periodEnd)
+		if err != nil {
+			return err
+		}
+		if isEtp {
+			p.Duration = m.Seconds2DurPtr(*cfg.EtpDuration)
+		}
+		for _, as := range p.AdaptationSets {
+			if isEtp && as.SegmentTemplate.SegmentTimeline != nil {
+				timeScale := uint64(as.SegmentTemplate.GetTimescale())
+				as.SegmentTemplate.SegmentTimeline.S = truncateLastS(as.SegmentTemplate.SegmentTimeline.S, uint64(periodEnd)*timeScale)
 			}
 			if cfg.ContMultiPeriodFlag {
 				periodContinuity := m.DescriptorType{
@@ -411,6 +535,9 @@ func splitPeriod(mpd *m.MPD, a *asset, cfg *ResponseConfig, wTimes wrapTimes) er
 				as.SupplementalProperties = append(as.SupplementalProperties, &periodContinuity)
 			}
 		}
+		if isXlinkPeriod(cfg, pNr) {
+			p = createXlinkPeriod(cfg, p.Id, p.Start)
+		}
 		periods = append(periods, p)
 	}
 	mpd.Periods = nil
@@ -420,8 +547,154 @@ func splitPeriod(mpd *m.MPD, a *asset, cfg *ResponseConfig, wTimes wrapTimes) er
 	return nil
 }
 
-func reduceS(entries []*m.S, startNr *uint32, timescale int, periodStartS, periodEndS uint64) ([]*m.S, *uint32) {
+// multiPeriodItvls returns the numbered periods overlapping the interval [windowStartMS, nowMS].
+// The period durations in seconds are repeated in a cycle starting at offsetS.
+// All times are relative to availabilityStartTime.
+func multiPeriodItvls(periodDurs []int, offsetS, windowStartMS, nowMS int) []periodItvl {
+	cycleDurMS := 0
+	for _, d := range periodDurs {
+		cycleDurMS += d * 1000
+	}
+	offsetMS := offsetS * 1000
+	startMS := max(windowStartMS-offsetMS, 0)
+	endMS := max(nowMS-offsetMS, 0)
+	cycleNr := startMS / cycleDurMS
+	pNr := cycleNr * len(periodDurs)
+	periodStartMS := cycleNr * cycleDurMS
+	var itvls []periodItvl
+	for ; periodStartMS <= endMS; pNr++ {
+		periodEndMS := periodStartMS + periodDurs[pNr%len(periodDurs)]*1000
+		if periodEndMS > startMS {
+			itvls = append(itvls, periodItvl{
+				id:     fmt.Sprintf("P%d", pNr),
+				nr:     pNr,
+				startS: (offsetMS + periodStartMS) / 1000,
+				endS:   (offsetMS + periodEndMS) / 1000,
+			})
+		}
+		periodStartMS = periodEndMS
+	}
+	return itvls
+}
+
+// isEtpPeriod returns true if period pNr is early terminated.
+// The cfg.EtpPeriodsPerHour early-terminated periods are evenly distributed over the hour.
+func isEtpPeriod(cfg *ResponseConfig, pNr int) bool {
+	if cfg.EtpPeriodsPerHour == nil {
+		return false
+	}
+	return pNr%(*cfg.PeriodsPerHour / *cfg.EtpPeriodsPerHour) == 0
+}
+
+// etpEndTime returns the end time of the early-terminated period that includes time t.
+// Both t and endTime are relative to availabilityStartTime in timescale units.
+// ok is false if t is not inside an early-terminated period.
+func etpEndTime(cfg *ResponseConfig, t, timescale uint64) (endTime uint64, ok bool) {
+	if cfg.EtpPeriodsPerHour == nil {
+		return 0, false
+	}
+	offset := uint64(cfg.getPeriodOffsetS()) * timescale
+	if t < offset {
+		return 0, false
+	}
+	periodDur := uint64(3600 / *cfg.PeriodsPerHour) * timescale
+	pNr := (t - offset) / periodDur
+	if !isEtpPeriod(cfg, int(pNr)) {
+		return 0, false
+	}
+	return offset + pNr*periodDur + uint64(*cfg.EtpDuration)*timescale, true
+}
+
+// truncateLastS shortens the last segment in entries so that it ends at endTime.
+func truncateLastS(entries []*m.S, endTime uint64) []*m.S {
+	if len(entries) == 0 {
+		return entries
+	}
 	var t uint64
+	for _, e := range entries {
+		if e.T != nil {
+			t = *e.T
+		}
+		t += e.D * uint64(e.R+1)
+	}
+	last := entries[len(entries)-1]
+	lastStart := t - last.D
+	if t <= endTime || lastStart >= endTime {
+		return entries
+	}
+	newD := endTime - lastStart
+	if last.R == 0 {
+		last.D = newD
+		return entries
+	}
+	last.R--
+	return append(entries, &m.S{D: newD})
+}
+
+// isXlinkPeriod returns true if period pNr should be signalled as a remote xlink Period.
+func isXlinkPeriod(cfg *ResponseConfig, pNr int) bool {
+	if cfg.XlinkPeriodsPerHour == nil {
+		return false
+	}
+	return pNr%*cfg.XlinkPeriodsPerHour == 0
+}
+
+// createXlinkPeriod creates an empty Period with an xlink:href to the resolved Period.
+func createXlinkPeriod(cfg *ResponseConfig, id string, start *m.Duration) *m.Period {
+	return &m.Period{
+		Id:           id,
+		Start:        start,
+		XlinkHref:    xlinkURL(cfg, id),
+		XlinkActuate: "onLoad",
+	}
+}
+
+// xlinkURL returns the URL to resolve the Period with the given id.
+// The URL path is the MPD path prefixed with /xlink.
+func xlinkURL(cfg *ResponseConfig, periodID string) string {
+	return fmt.Sprintf("%s/xlink%s?period=%s", cfg.Host, strings.Join(cfg.URLParts, "/"), url.QueryEscape(periodID))
+}
+
+// createPeriod creates a Period covering [startS, endS) from the single live period inPeriod.
+// The times are in seconds relative to availabilityStartTime, and the segment times are
+// kept, so the presentationTimeOffset corresponds to startS.
+func createPeriod(inPeriod *m.Period, cfg *ResponseConfig, id string, startS, endS int) (*m.Period, error) {
+	p := inPeriod.Clone()
+	p.Id = id
+	p.Start = m.Seconds2DurPtr(startS)
+	periodStart, periodEnd := uint64(startS), uint64(endS)
+	for aNr, as := range p.AdaptationSets {
+		inAS := inPeriod.AdaptationSets[aNr]
+		timeScale := int(as.SegmentTemplate.GetTimescale())
+		pto := Ptr(uint64(startS * timeScale))
+		templateType := cfg.liveMPDType()
+		if as.ContentType == "image" {
+			templateType = segmentNumber
+		}
+		switch templateType {
+		case segmentNumber:
+			as.SegmentTemplate.PresentationTimeOffset = pto
+			segDur := int(*as.SegmentTemplate.Duration)
+			startNr := uint32(cfg.getStartNr() + startS*timeScale/segDur)
+			as.SegmentTemplate.StartNumber = Ptr(startNr)
+		case timeLineTime:
+			as.SegmentTemplate.PresentationTimeOffset = pto
+			inS := inAS.SegmentTemplate.SegmentTimeline.S
+			as.SegmentTemplate.SegmentTimeline.S, _ = reduceS(inS, nil, timeScale, periodStart, periodEnd)
+		case timeLineNumber:
+			as.SegmentTemplate.PresentationTimeOffset = pto
+			inS := inAS.SegmentTemplate.SegmentTimeline.S
+			startNr := inAS.SegmentTemplate.StartNumber
+			as.SegmentTemplate.SegmentTimeline.S, as.SegmentTemplate.StartNumber = reduceS(inS, startNr, timeScale, periodStart, periodEnd)
+		default:
+			return nil, fmt.Errorf("unknown mpd type")
+		}
+	}
+	return p, nil
+}
+
+func reduceS(entries []*m.S, startNr *uint32, timescale int, periodStartS, periodEndS uint64) ([]*m.S, *uint32) {
+	var t, d uint64
 	pStart := periodStartS * uint64(timescale)
 	pEnd := periodEndS * uint64(timescale)
 	nr := uint32(0)
@@ -432,10 +705,12 @@ func reduceS(entries []*m.S, startNr *uint32, timescale int, periodStartS, perio
 	newS := make([]*m.S, 0, len(entries))
 	var currS *m.S
 	for _, e := range entries {
+		gap := false // A time jump in the timeline must be kept
 		if e.T != nil {
+			gap = *e.T != t
 			t = *e.T
 		}
-		d := e.D
+		d = e.D
 		for i := 0; i <= e.R; i++ {
 			if t < pStart {
 				t += d
@@ -453,7 +728,7 @@ func reduceS(entries []*m.S, startNr *uint32, timescale int, periodStartS, perio
 				outStartNr = nr
 				newS = append(newS, currS)
 			} else {
-				if d == currS.D {
+				if d == currS.D && !(gap && i == 0) {
 					currS.R++
 				} else {
 					currS = &m.S{
@@ -466,6 +741,13 @@ func reduceS(entries []*m.S, startNr *uint32, timescale int, periodStartS, perio
 			t += d
 		}
 	}
+	if currS == nil {
+		// No segment in the period yet. The first one gets the next number.
+		if t < pStart && d > 0 {
+			nr += uint32((pStart - t + d - 1) / d)
+		}
+		outStartNr = nr
+	}
 	return newS, &outStartNr
 }
 
@@ -494,13 +776,16 @@ func createServiceDescription(latencyTargetMS uint32) []*m.ServiceDescriptionTyp
 	}
 }
 
-func createProducerReferenceTimes(startTimeS int) []*m.ProducerReferenceTimeType {
+// createProducerReferenceTimes returns a ProducerReferenceTime mapping media time 0 to availabilityStartTime.
+// With inband prft boxes, the wall-clock time is shifted by the encoder delay like in the boxes.
+func createProducerReferenceTimes(cfg *ResponseConfig) []*m.ProducerReferenceTimeType {
 	return []*m.ProducerReferenceTimeType{
 		{
 			Id:               0,
+			Inband:           cfg.PrftDelayMS != nil,
 			PresentationTime: 0,
 			Type:             "encoder",
-			WallClockTime:    string(m.ConvertToDateTime(float64(startTimeS))),
+			WallClockTime:    string(m.ConvertToDateTimeMS(prftWallClockMS(cfg, 0, 1))),
 			UTCTiming: &m.DescriptorType{
 				SchemeIdUri: UtcTimingHttpXSDateScheme,
 				Value:       UtcTimingXSDateHttpServerMS,
@@ -537,6 +822,54 @@ func (s segEntries) lastTime() uint64 {
 	return t - lastD
 }
 
+// Segments starting in the interval [timelineLossStartS, timelineLossStartS+timelineLossDurS)
+// of every timelineLossCycleS are lost when segtimelineloss is set.
+const (
+	timelineLossCycleS = 60
+	timelineLossStartS = 30
+	timelineLossDurS   = 6
+)
+
+// isLostSegment returns true if the segment starting at media time t is lost.
+// The nominal segment duration is used so that all representations lose the same segments.
+func isLostSegment(a *asset, t uint64, timescale uint32) bool {
+	segDurMS := uint64(a.SegmentDurMS)
+	// Round to the nearest nominal segment start, since audio segments do not start exactly there
+	idx := (t*1000/uint64(timescale) + segDurMS/2) / segDurMS
+	startMS := idx * segDurMS % (timelineLossCycleS * 1000)
+	return startMS >= timelineLossStartS*1000 && startMS < (timelineLossStartS+timelineLossDurS)*1000
+}
+
+// dropLostSegments returns new timeline entries without the lost segments.
+// The entry times are in timescale units. Entries following a gap get an explicit time.
+func dropLostSegments(a *asset, entries []*m.S, timescale uint32) []*m.S {
+	out := make([]*m.S, 0, len(entries))
+	var t uint64
+	var currS *m.S
+	for _, e := range entries {
+		if e.T != nil {
+			t = *e.T
+		}
+		for i := 0; i <= e.R; i++ {
+			switch {
+			case isLostSegment(a, t, timescale):
+				currS = nil
+			case currS != nil && currS.D == e.D:
+				currS.R++
+			default:
+				newS := &m.S{D: e.D}
+				if currS == nil {
+					newS.T = Ptr(t)
+				}
+				currS = newS
+				out = append(out, currS)
+			}
+			t += e.D
+		}
+	}
+	return out
+}
+
 // setOffsetInAdaptationSet sets the availabilityTimeOffset in the AdaptationSet.
 // Returns ErrAtoInfTimeline if infinite ato set with timeline.
 func setOffsetInAdaptationSet(cfg *ResponseConfig, as *m.AdaptationSetType) (atoMS int, err error) {
@@ -556,7 +889,7 @@ func setOffsetInAdaptationSet(cfg *ResponseConfig, as *m.AdaptationSetType) (ato
 		as.SegmentTemplate.AvailabilityTimeComplete = Ptr(false)
 		if cfg.getAvailabilityTimeOffsetS() > 0 {
 			as.SegmentTemplate.AvailabilityTimeOffset = m.FloatInf64(cfg.getAvailabilityTimeOffsetS())
-			as.ProducerReferenceTimes = createProducerReferenceTimes(cfg.StartTimeS)
+			as.ProducerReferenceTimes = createProducerReferenceTimes(cfg)
 		}
 	}
 	atoMS = int(1000 * ato)
@@ -575,7 +908,7 @@ func adjustAdaptationSetForTimelineTime(se segEntries, as *m.AdaptationSetType)
 	return nil
 }
 
-func adjustAdaptationSetForTimelineNr(se segEntries, as *m.AdaptationSetType) error {
+func adjustAdaptationSetForTimelineNr(cfg *ResponseConfig, se segEntries, as *m.AdaptationSetType) error {
 	if as.SegmentTemplate.SegmentTimeline == nil {
 		as.SegmentTemplate.SegmentTimeline = &m.SegmentTimelineType{}
 	}
@@ -586,7 +919,11 @@ func adjustAdaptationSetForTimelineNr(se segEntries, as *m.AdaptationSetType) er
 	as.SegmentTemplate.SegmentTimeline.S = se.entries
 
 	if se.startNr >= 0 {
-		as.SegmentTemplate.StartNumber = Ptr(uint32(se.startNr))
+		startNr := se.startNr
+		if cfg.StartNr != nil {
+			startNr += *cfg.StartNr
+		}
+		as.SegmentTemplate.StartNumber = Ptr(uint32(startNr))
 	}
 	return nil
 }
@@ -5,10 +5,12 @@
 package app
 
 import (
+	"fmt"
 	"log/slog"
 	"math"
 	"os"
 	"path"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -899,6 +901,296 @@ func TestMultiPeriod(t *testing.T) {
 	}
 }
 
+func TestEarlyTerminatedPeriods(t *testing.T) {
+	vodFS := os.DirFS("testdata/assets")
+	am := newAssetMgr(vodFS, "", false)
+	logger := slog.Default()
+	err := am.discoverAssets(logger)
+	require.NoError(t, err)
+	asset, ok := am.findAsset("testpic_2s")
+	require.True(t, ok)
+
+	nowMS := 1001_000
+	for _, stl := range []string{"", "segtimeline_1/", "segtimelinenr_1/"} {
+		t.Run(stl, func(t *testing.T) {
+			cfg, err := processURLCfg("/livesim2/periods_60/etp_30/etpDuration_31/"+stl+"testpic_2s/Manifest.mpd", nowMS)
+			require.NoError(t, err)
+			liveMPD, err := LiveMPD(asset, "Manifest.mpd", cfg, nil, nowMS)
+			require.NoError(t, err)
+			require.Equal(t, 2, len(liveMPD.Periods))
+			p15, p16 := liveMPD.Periods[0], liveMPD.Periods[1]
+			require.Nil(t, p15.Duration)
+			require.Equal(t, m.Seconds2DurPtr(31), p16.Duration)
+			if stl == "" {
+				return
+			}
+			for _, as := range p16.AdaptationSets {
+				st := as.SegmentTemplate
+				timescale := uint64(st.GetTimescale())
+				periodEnd := (960 + 31) * timescale
+				lastS := st.SegmentTimeline.S[len(st.SegmentTimeline.S)-1]
+				var end uint64
+				for _, s := range st.SegmentTimeline.S {
+					if s.T != nil {
+						end = *s.T
+					}
+					end += s.D * uint64(s.R+1)
+				}
+				require.Equal(t, periodEnd, end, "contentType %s", as.ContentType)
+				require.Less(t, lastS.D, 2*timescale)
+			}
+		})
+	}
+}
+
+func TestEarlyTerminatedPeriodsConfig(t *testing.T) {
+	cases := []struct {
+		url          string
+		wantedErr    string
+		wantedEtpDur int
+	}{
+		{url: "/livesim2/periods_60/etp_30/asset.mpd", wantedEtpDur: 30},
+		{url: "/livesim2/periods_60/etp_30/etpDuration_10/asset.mpd", wantedEtpDur: 10},
+		{url: "/livesim2/etp_30/asset.mpd", wantedErr: "early-terminated periods set, but not multiple periods per hour"},
+		{url: "/livesim2/periods_60/etp_7/asset.mpd", wantedErr: "etp 7 must be a divisor of periods per hour 60"},
+		{url: "/livesim2/periods_60/etp_30/etpDuration_60/asset.mpd", wantedErr: "etpDuration 60s must be shorter than period duration 60s"},
+		{url: "/livesim2/periods_60/etpDuration_10/asset.mpd", wantedErr: "etpDuration set, but not etp"},
+	}
+	for _, tc := range cases {
+		cfg, err := processURLCfg(tc.url, 100_000)
+		if tc.wantedErr != "" {
+			require.ErrorContains(t, err, tc.wantedErr)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, tc.wantedEtpDur, *cfg.EtpDuration)
+	}
+}
+
+func TestPeriodOffset(t *testing.T) {
+	vodFS := os.DirFS("testdata/assets")
+	am := newAssetMgr(vodFS, "", false)
+	logger := slog.Default()
+	err := am.discoverAssets(logger)
+	require.NoError(t, err)
+	asset, ok := am.findAsset("testpic_2s")
+	require.True(t, ok)
+
+	cases := []struct {
+		desc            string
+		url             string
+		nowMS           int
+		wantedIDs       []string
+		wantedStarts    []int
+		wantedStartNrs  []int
+		wantedFirstTime uint64
+		wantedErr       string
+	}{
+		{
+			desc:           "single period $Number$",
+			url:            "/livesim2/peroff_10/testpic_2s/Manifest.mpd",
+			nowMS:          50_000,
+			wantedIDs:      []string{"P0"},
+			wantedStarts:   []int{10},
+			wantedStartNrs: []int{5},
+		},
+		{
+			desc:            "single period $Time$",
+			url:             "/livesim2/peroff_10/segtimeline_1/testpic_2s/Manifest.mpd",
+			nowMS:           50_000,
+			wantedIDs:       []string{"P0"},
+			wantedStarts:    []int{10},
+			wantedFirstTime: 10 * 90000,
+		},
+		{
+			desc:           "multiple periods $Number$",
+			url:            "/livesim2/periods_60/peroff_10/testpic_2s/Manifest.mpd",
+			nowMS:          1001_000,
+			wantedIDs:      []string{"P15", "P16"},
+			wantedStarts:   []int{910, 970},
+			wantedStartNrs: []int{455, 485},
+		},
+		{
+			desc:      "offset not aligned with segments",
+			url:       "/livesim2/peroff_3/testpic_2s/Manifest.mpd",
+			nowMS:     50_000,
+			wantedErr: "period offset 3s not a multiple of segment duration 2000ms",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			cfg, err := processURLCfg(tc.url, tc.nowMS)
+			require.NoError(t, err)
+			liveMPD, err := LiveMPD(asset, "Manifest.mpd", cfg, nil, tc.nowMS)
+			if tc.wantedErr != "" {
+				require.ErrorContains(t, err, tc.wantedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, len(tc.wantedIDs), len(liveMPD.Periods))
+			for i, p := range liveMPD.Periods {
+				require.Equal(t, tc.wantedIDs[i], p.Id)
+				require.Equal(t, m.Seconds2DurPtr(tc.wantedStarts[i]), p.Start)
+				for _, as := range p.AdaptationSets {
+					if as.ContentType != "video" {
+						continue
+					}
+					st := as.SegmentTemplate
+					require.Equal(t, uint64(tc.wantedStarts[i])*uint64(st.GetTimescale()), *st.PresentationTimeOffset)
+					if tc.wantedStartNrs != nil {
+						require.Equal(t, tc.wantedStartNrs[i], int(*st.StartNumber))
+					}
+					if tc.wantedFirstTime != 0 {
+						require.Equal(t, tc.wantedFirstTime, *st.SegmentTimeline.S[0].T)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPeriodDurations(t *testing.T) {
+	vodFS := os.DirFS("testdata/assets")
+	am := newAssetMgr(vodFS, "", false)
+	logger := slog.Default()
+	err := am.discoverAssets(logger)
+	require.NoError(t, err)
+	asset, ok := am.findAsset("testpic_2s")
+	require.True(t, ok)
+
+	nowMS := 1001_000
+	cases := []struct {
+		desc           string
+		url            string
+		wantedStartNrs []int
+		wantedErr      string
+	}{
+		{
+			desc:           "$Number$",
+			url:            "/livesim2/dur_30/dur_60/dur_40/testpic_2s/Manifest.mpd",
+			wantedStartNrs: []int{470, 500},
+		},
+		{
+			desc: "timelineTime",
+			url:  "/livesim2/dur_30/dur_60/dur_40/segtimeline_1/testpic_2s/Manifest.mpd",
+		},
+		{
+			desc:           "timelineNumber",
+			url:            "/livesim2/dur_30/dur_60/dur_40/segtimelinenr_1/testpic_2s/Manifest.mpd",
+			wantedStartNrs: []int{470, 500},
+		},
+		{
+			desc:      "duration not multiple of segment duration",
+			url:       "/livesim2/dur_30/dur_45/testpic_2s/Manifest.mpd",
+			wantedErr: "splitPeriods: period duration 45s not a multiple of segment duration 2000ms",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			cfg, err := processURLCfg(tc.url, nowMS)
+			require.NoError(t, err)
+			liveMPD, err := LiveMPD(asset, "Manifest.mpd", cfg, nil, nowMS)
+			if tc.wantedErr != "" {
+				require.EqualError(t, err, tc.wantedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, 2, len(liveMPD.Periods))
+			wantedIDs := []string{"P22", "P23"}
+			wantedStarts := []int{940, 1000}
+			for i, p := range liveMPD.Periods {
+				require.Equal(t, wantedIDs[i], p.Id)
+				require.Equal(t, m.Seconds2DurPtr(wantedStarts[i]), p.Start)
+				for _, as := range p.AdaptationSets {
+					st := as.SegmentTemplate
+					require.Equal(t, uint64(wantedStarts[i])*uint64(st.GetTimescale()), *st.PresentationTimeOffset)
+					if tc.wantedStartNrs != nil && as.ContentType == "video" {
+						require.Equal(t, tc.wantedStartNrs[i], int(*st.StartNumber))
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestMultiPeriodItvls(t *testing.T) {
+	cases := []struct {
+		desc          string
+		periodDurs    []int
+		offsetS       int
+		windowStartMS int
+		nowMS         int
+		wantedItvls   []periodItvl
+	}{
+		{
+			desc:          "uniform periods",
+			periodDurs:    []int{60},
+			windowStartMS: 941_000,
+			nowMS:         1001_000,
+			wantedItvls: []periodItvl{
+				{id: "P15", nr: 15, startS: 900, endS: 960},
+				{id: "P16", nr: 16, startS: 960, endS: 1020},
+			},
+		},
+		{
+			desc:          "cycle of durations",
+			periodDurs:    []int{30, 60, 40},
+			windowStartMS: 100_000,
+			nowMS:         160_000,
+			wantedItvls: []periodItvl{
+				{id: "P2", nr: 2, startS: 90, endS: 130},
+				{id: "P3", nr: 3, startS: 130, endS: 160},
+				{id: "P4", nr: 4, startS: 160, endS: 220},
+			},
+		},
+		{
+			desc:          "cycle with offset before first period",
+			periodDurs:    []int{30, 60},
+			offsetS:       20,
+			windowStartMS: -40_000,
+			nowMS:         20_000,
+			wantedItvls: []periodItvl{
+				{id: "P0", nr: 0, startS: 20, endS: 50},
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			itvls := multiPeriodItvls(tc.periodDurs, tc.offsetS, tc.windowStartMS, tc.nowMS)
+			require.Equal(t, tc.wantedItvls, itvls)
+		})
+	}
+}
+
+func TestTfdt32AvailabilityStartTime(t *testing.T) {
+	vodFS := os.DirFS("testdata/assets")
+	am := newAssetMgr(vodFS, "", false)
+	logger := slog.Default()
+	err := am.discoverAssets(logger)
+	require.NoError(t, err)
+	asset, ok := am.findAsset("testpic_2s")
+	require.True(t, ok)
+
+	nowMS := 100_000_000_000
+	cases := []struct {
+		url       string
+		wantedErr string
+	}{
+		{url: "/livesim2/tfdt_32/testpic_2s/Manifest.mpd", wantedErr: "tfdt_32: decode time"},
+		{url: "/livesim2/tfdt_32/start_99999000/testpic_2s/Manifest.mpd"},
+	}
+	for _, tc := range cases {
+		cfg, err := processURLCfg(tc.url, nowMS)
+		require.NoError(t, err)
+		_, err = LiveMPD(asset, "Manifest.mpd", cfg, nil, nowMS)
+		if tc.wantedErr != "" {
+			require.ErrorContains(t, err, tc.wantedErr)
+			continue
+		}
+		require.NoError(t, err)
+	}
+}
+
 func TestRelStartStopTimeIntoLocation(t *testing.T) {
 	vodFS := os.DirFS("testdata/assets")
 	am := newAssetMgr(vodFS, "", false)
@@ -935,6 +1227,283 @@ func TestRelStartStopTimeIntoLocation(t *testing.T) {
 	}
 }
 
+func TestContUpdate(t *testing.T) {
+	vodFS := os.DirFS("testdata/assets")
+	am := newAssetMgr(vodFS, "", false)
+	logger := slog.Default()
+	err := am.discoverAssets(logger)
+	require.NoError(t, err)
+	asset, ok := am.findAsset("testpic_2s")
+	require.True(t, ok)
+
+	nowMS := 1001_000
+	cases := []struct {
+		desc           string
+		url            string
+		wantedASTS     int
+		wantedStartNr  int
+		wantedBaseURL  string
+		wantedLocation string
+	}{
+		{
+			desc:          "$Number$",
+			url:           "/livesim2/cont_1/testpic_2s/Manifest.mpd",
+			wantedASTS:    940,
+			wantedStartNr: 470,
+			wantedBaseURL: "../../cont_1/start_940/snr_470/testpic_2s/",
+		},
+		{
+			desc:          "timelineTime",
+			url:           "/livesim2/cont_1/segtimeline_1/testpic_2s/Manifest.mpd",
+			wantedASTS:    940,
+			wantedStartNr: 470,
+			wantedBaseURL: "../../../cont_1/segtimeline_1/start_940/snr_470/testpic_2s/",
+		},
+		{
+			desc:          "timelineNumber",
+			url:           "/livesim2/cont_1/segtimelinenr_1/testpic_2s/Manifest.mpd",
+			wantedASTS:    940,
+			wantedStartNr: 470,
+			wantedBaseURL: "../../../cont_1/segtimelinenr_1/start_940/snr_470/testpic_2s/",
+		},
+		{
+			desc:           "relative start with location",
+			url:            "/livesim2/startrel_-100/cont_1/testpic_2s/Manifest.mpd",
+			wantedASTS:     941,
+			wantedStartNr:  20,
+			wantedBaseURL:  "../../../cont_1/start_941/snr_20/testpic_2s/",
+			wantedLocation: "http://localhost:8888/livesim2/start_901/cont_1/testpic_2s/Manifest.mpd",
+		},
+		{
+			desc:          "start and startNumber replaced",
+			url:           "/livesim2/start_901/snr_5/cont_1/testpic_2s/Manifest.mpd",
+			wantedASTS:    941,
+			wantedStartNr: 25,
+			wantedBaseURL: "../../../../cont_1/start_941/snr_25/testpic_2s/",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			cfg, err := processURLCfg(tc.url, nowMS)
+			require.NoError(t, err)
+			cfg.SetHost("http://localhost:8888", nil)
+			liveMPD, err := LiveMPD(asset, "Manifest.mpd", cfg, nil, nowMS)
+			require.NoError(t, err)
+			require.Equal(t, m.ConvertToDateTime(float64(tc.wantedASTS)), liveMPD.AvailabilityStartTime)
+			p := liveMPD.Periods[0]
+			require.Equal(t, 1, len(p.BaseURLs))
+			require.Equal(t, tc.wantedBaseURL, string(p.BaseURLs[0].Value))
+			if tc.wantedLocation != "" {
+				require.Equal(t, tc.wantedLocation, string(liveMPD.Location[0]))
+			}
+			for _, as := range p.AdaptationSets {
+				if as.ContentType != "video" {
+					continue
+				}
+				st := as.SegmentTemplate
+				switch {
+				case st.SegmentTimeline == nil:
+					require.Equal(t, tc.wantedStartNr, int(*st.StartNumber))
+				case cfg.SegTimelineNrFlag:
+					require.LessOrEqual(t, tc.wantedStartNr, int(*st.StartNumber))
+				}
+			}
+
+			// The segments must be available with the moved availabilityStartTime and startNumber
+			segURL := "/livesim2/" + strings.ReplaceAll(tc.wantedBaseURL, "../", "") + "V300/init.mp4"
+			segCfg, err := processURLCfg(segURL, nowMS)
+			require.NoError(t, err)
+			require.Equal(t, tc.wantedASTS, segCfg.StartTimeS)
+			require.Equal(t, tc.wantedStartNr, segCfg.getStartNr())
+		})
+	}
+}
+
+func TestContUpdateSegmentNumbering(t *testing.T) {
+	vodFS := os.DirFS("testdata/assets")
+	am := newAssetMgr(vodFS, "", false)
+	log := slog.Default()
+	err := am.discoverAssets(log)
+	require.NoError(t, err)
+	asset, ok := am.findAsset("testpic_2s")
+	require.True(t, ok)
+
+	nowMS := 1001_000
+	cfg, err := processURLCfg("/livesim2/testpic_2s/Manifest.mpd", nowMS)
+	require.NoError(t, err)
+	refSo, err := genLiveSegment(log, vodFS, asset, cfg, "V300/490.m4s", nowMS, false /*isLast */)
+	require.NoError(t, err)
+	contCfg, err := processURLCfg("/livesim2/cont_1/start_940/snr_470/testpic_2s/V300/490.m4s", nowMS)
+	require.NoError(t, err)
+	so, err := genLiveSegment(log, vodFS, asset, contCfg, "V300/490.m4s", nowMS, false /*isLast */)
+	require.NoError(t, err)
+	require.Equal(t, refSo.meta.newNr, so.meta.newNr)
+	require.Equal(t, refSo.meta.newTime-940*90000, so.meta.newTime)
+}
+
+func TestSegmentTimelineLoss(t *testing.T) {
+	vodFS := os.DirFS("testdata/assets")
+	am := newAssetMgr(vodFS, "", false)
+	log := slog.Default()
+	err := am.discoverAssets(log)
+	require.NoError(t, err)
+	asset, ok := am.findAsset("testpic_2s")
+	require.True(t, ok)
+
+	_, err = processURLCfg("/livesim2/segtimelineloss_1/testpic_2s/Manifest.mpd", 0)
+	require.EqualError(t, err, "url config: segtimelineloss requires segtimeline")
+
+	nowMS := 1001_000
+	segTimes := func(cfgURL string) map[string][]uint64 {
+		cfg, err := processURLCfg(cfgURL, nowMS)
+		require.NoError(t, err)
+		liveMPD, err := LiveMPD(asset, "Manifest.mpd", cfg, nil, nowMS)
+		require.NoError(t, err)
+		times := make(map[string][]uint64)
+		for _, as := range liveMPD.Periods[0].AdaptationSets {
+			var t uint64
+			for _, s := range as.SegmentTemplate.SegmentTimeline.S {
+				if s.T != nil {
+					t = *s.T
+				}
+				for i := 0; i <= s.R; i++ {
+					times[string(as.ContentType)] = append(times[string(as.ContentType)], t)
+					t += s.D
+				}
+			}
+		}
+		return times
+	}
+	allTimes := segTimes("/livesim2/segtimeline_1/testpic_2s/Manifest.mpd")
+	lossURL := "/livesim2/segtimelineloss_1/segtimeline_1/testpic_2s/Manifest.mpd"
+	lossTimes := segTimes(lossURL)
+	for _, contentType := range []string{"video", "audio"} {
+		require.Equal(t, len(allTimes[contentType])-3, len(lossTimes[contentType]), contentType)
+	}
+	var lostVideoTimes []uint64
+	for _, vt := range allTimes["video"] {
+		if !slices.Contains(lossTimes["video"], vt) {
+			lostVideoTimes = append(lostVideoTimes, vt)
+		}
+	}
+	require.Equal(t, []uint64{990 * 90000, 992 * 90000, 994 * 90000}, lostVideoTimes)
+	var lostAudioTime uint64
+	for _, at := range allTimes["audio"] {
+		if !slices.Contains(lossTimes["audio"], at) {
+			lostAudioTime = at
+			break
+		}
+	}
+
+	cfg, err := processURLCfg(lossURL, nowMS)
+	require.NoError(t, err)
+	cases := []struct {
+		media     string
+		wantedErr error
+	}{
+		{media: fmt.Sprintf("V300/%d.m4s", 988*90000)},
+		{media: fmt.Sprintf("V300/%d.m4s", 990*90000), wantedErr: errNotFound},
+		{media: fmt.Sprintf("V300/%d.m4s", 996*90000)},
+		{media: fmt.Sprintf("A48/%d.m4s", lostAudioTime), wantedErr: errNotFound},
+	}
+	for _, tc := range cases {
+		_, err := genLiveSegment(log, vodFS, asset, cfg, tc.media, nowMS, false /*isLast */)
+		if tc.wantedErr != nil {
+			require.ErrorIs(t, err, tc.wantedErr, tc.media)
+			continue
+		}
+		require.NoError(t, err, tc.media)
+	}
+}
+
+func TestModuloSessions(t *testing.T) {
+	vodFS := os.DirFS("testdata/assets")
+	am := newAssetMgr(vodFS, "", false)
+	log := slog.Default()
+	err := am.discoverAssets(log)
+	require.NoError(t, err)
+	asset, ok := am.findAsset("testpic_2s")
+	require.True(t, ok)
+
+	cases := []struct {
+		desc          string
+		nowMS         int
+		wantedType    string
+		wantedMediaNr int
+		wantedSegErr  error
+	}{
+		{desc: "before session", nowMS: 3_630_000, wantedType: "dynamic", wantedMediaNr: 0, wantedSegErr: errNotFound},
+		{desc: "live session", nowMS: 3_700_000, wantedType: "dynamic", wantedMediaNr: 10},
+		{desc: "just after session", nowMS: 3_970_000, wantedType: "static", wantedMediaNr: 140},
+		{desc: "after session", nowMS: 4_100_000, wantedType: "static", wantedMediaNr: 10, wantedSegErr: errNotFound},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			cfg, err := processURLCfg("/livesim2/modulo_10/testpic_2s/Manifest.mpd", tc.nowMS)
+			require.NoError(t, err)
+			require.Equal(t, 3660, cfg.StartTimeS)
+			require.Equal(t, 3960, *cfg.StopTimeS)
+			require.Equal(t, 3600_000, cfg.initAvailTimeMS())
+			liveMPD, err := LiveMPD(asset, "Manifest.mpd", cfg, nil, tc.nowMS)
+			require.NoError(t, err)
+			require.Equal(t, tc.wantedType, *liveMPD.Type)
+			require.Equal(t, m.Seconds2DurPtr(300), liveMPD.MediaPresentationDuration)
+			if tc.wantedType == "dynamic" {
+				require.Equal(t, m.ConvertToDateTime(3660), liveMPD.AvailabilityStartTime)
+			}
+			media := fmt.Sprintf("V300/%d.m4s", tc.wantedMediaNr)
+			_, err = genLiveSegment(log, vodFS, asset, cfg, media, tc.nowMS, false /*isLast */)
+			if tc.wantedSegErr != nil {
+				require.ErrorIs(t, err, tc.wantedSegErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+
+	_, err = processURLCfg("/livesim2/modulo_7/testpic_2s/Manifest.mpd", 0)
+	require.EqualError(t, err, "url config: modulo 7 must be a divisor of 60")
+	_, err = processURLCfg("/livesim2/start_100/modulo_10/testpic_2s/Manifest.mpd", 200_000)
+	require.EqualError(t, err, "url config: modulo cannot be combined with start or stop")
+}
+
+func TestSegTimelineNrStartNumber(t *testing.T) {
+	vodFS := os.DirFS("testdata/assets")
+	am := newAssetMgr(vodFS, "", false)
+	log := slog.Default()
+	err := am.discoverAssets(log)
+	require.NoError(t, err)
+	asset, ok := am.findAsset("testpic_2s")
+	require.True(t, ok)
+
+	nowMS := 100_000
+	firstS := func(url string) (startNr int, firstT uint64) {
+		cfg, err := processURLCfg(url, nowMS)
+		require.NoError(t, err)
+		liveMPD, err := LiveMPD(asset, "Manifest.mpd", cfg, nil, nowMS)
+		require.NoError(t, err)
+		for _, as := range liveMPD.Periods[0].AdaptationSets {
+			if as.ContentType == "video" {
+				st := as.SegmentTemplate
+				return int(*st.StartNumber), *st.SegmentTimeline.S[0].T
+			}
+		}
+		t.Fatal("no video AdaptationSet")
+		return 0, 0
+	}
+	refNr, refT := firstS("/livesim2/segtimelinenr_1/testpic_2s/Manifest.mpd")
+	startNr, firstT := firstS("/livesim2/segtimelinenr_1/snr_10/testpic_2s/Manifest.mpd")
+	require.Equal(t, refNr+10, startNr)
+	require.Equal(t, refT, firstT)
+
+	// The segment with the startNumber must be the first segment in the SegmentTimeline
+	cfg, err := processURLCfg("/livesim2/segtimelinenr_1/snr_10/testpic_2s/V300/init.mp4", nowMS)
+	require.NoError(t, err)
+	so, err := genLiveSegment(log, vodFS, asset, cfg, fmt.Sprintf("V300/%d.m4s", startNr), nowMS, false /*isLast */)
+	require.NoError(t, err)
+	require.Equal(t, firstT, so.meta.newTime)
+}
+
 func TestFractionalFramerateMPDs(t *testing.T) {
 	vodFS := os.DirFS("testdata/assets")
 	tmpDir := t.TempDir()
@@ -5,8 +5,10 @@
 package app
 
 import (
+	"bytes"
 	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io/fs"
 	"log/slog"
@@ -30,6 +32,9 @@ import (
 func genLiveSegment(log *slog.Logger, vodFS fs.FS, a *asset, cfg *ResponseConfig,
 	segmentPart string, nowMS int, isLast bool) (segOut, error) {
 	var so segOut
+	if !cfg.inModuloSession(nowMS) {
+		return so, errNotFound
+	}
 
 	outSeg, err := createOutSeg(vodFS, a, cfg, segmentPart, nowMS)
 	if err != nil {
@@ -40,7 +45,6 @@ func genLiveSegment(log *slog.Logger, vodFS fs.FS, a *asset, cfg *ResponseConfig
 	}
 	if outSeg.data != nil { // Non-processed mp4-file
 		meta := outSeg.meta
-		contentType := meta.rep.ContentType
 		sr := bits.NewFixedSliceReader(outSeg.data)
 		segFile, err := mp4.DecodeFileSR(sr)
 		if err != nil {
@@ -90,22 +94,75 @@ func genLiveSegment(log *slog.Logger, vodFS fs.FS, a *asset, cfg *ResponseConfig
 				}
 			}
 		}
-
-		if cfg.SCTE35PerMinute != nil && contentType == "video" {
-			startTime := uint64(meta.newTime)
-			endTime := startTime + uint64(meta.newDur)
-			timescale := uint64(meta.timescale)
-			emsg, err := scte35.CreateEmsgAhead(startTime, endTime, timescale, *cfg.SCTE35PerMinute)
+		outSeg.seg = seg
+		outSeg.data = nil
+	}
+	contentType := outSeg.meta.rep.ContentType
+	if cfg.EtpPeriodsPerHour != nil && (contentType == "video" || contentType == "audio") {
+		err = terminateAtPeriodEnd(cfg, &outSeg)
+		if err != nil {
+			return so, err
+		}
+	}
+	if cfg.Tfdt32Flag {
+		err = forceTfdtVersion0(outSeg.seg.Fragments)
+		if err != nil {
+			return so, err
+		}
+	}
+	if cfg.scte35Inband() && contentType == "video" {
+		meta := outSeg.meta
+		startTime := uint64(meta.newTime)
+		endTime := startTime + uint64(meta.newDur)
+		timescale := uint64(meta.timescale)
+		var emsgs []*mp4.EmsgBox
+		if segType, ok := scte35.SegmentationStartTypes[cfg.SCTE35Cmd]; ok {
+			emsgs, err = cfg.SCTE35.CreateTimeSignalEmsgs(startTime, endTime, timescale, segType)
 			if err != nil {
 				return so, fmt.Errorf("insertSCTE35: %w", err)
 			}
-			if emsg != nil {
-				seg.Fragments[0].AddEmsg(emsg)
-				log.Debug("added SCTE-35 emsg message", "asset", a.AssetPath, "segment", segmentPart)
+		} else {
+			emsgs = cfg.SCTE35.CreateEmsgs(startTime, endTime, timescale)
+		}
+		for _, emsg := range emsgs {
+			outSeg.seg.Fragments[0].AddEmsg(emsg)
+			log.Debug("added SCTE-35 emsg message", "asset", a.AssetPath, "segment", segmentPart, "id", emsg.ID)
+		}
+	}
+	if cfg.ID3IntervalS != nil && contentType == "video" {
+		meta := outSeg.meta
+		startTime := uint64(meta.newTime)
+		emsgs, err := id3Emsgs(cfg, meta.newNr, startTime, startTime+uint64(meta.newDur), uint64(meta.timescale))
+		if err != nil {
+			return so, err
+		}
+		for _, emsg := range emsgs {
+			outSeg.seg.Fragments[0].AddEmsg(emsg)
+			log.Debug("added ID3 emsg message", "asset", a.AssetPath, "segment", segmentPart, "id", emsg.ID)
+		}
+	}
+	if cfg.callbackInband() && contentType == "video" {
+		meta := outSeg.meta
+		startTime := uint64(meta.newTime)
+		for _, emsg := range callbackEmsgs(cfg, startTime, startTime+uint64(meta.newDur), uint64(meta.timescale)) {
+			outSeg.seg.Fragments[0].AddEmsg(emsg)
+			log.Debug("added callback emsg message", "asset", a.AssetPath, "segment", segmentPart, "id", emsg.ID)
+		}
+	}
+	if cfg.MPDExpiryLeadS != nil && (contentType == "video" || contentType == "audio") {
+		meta := outSeg.meta
+		startTime := uint64(meta.newTime)
+		for _, emsg := range mpdExpiryEmsgs(cfg, startTime, startTime+uint64(meta.newDur), uint64(meta.timescale)) {
+			outSeg.seg.Fragments[0].AddEmsg(emsg)
+			log.Debug("added MPD expiry emsg message", "asset", a.AssetPath, "segment", segmentPart, "id", emsg.ID)
+		}
+	}
+	if cfg.PrftDelayMS != nil {
+		for _, frag := range outSeg.seg.Fragments {
+			if err := addPrft(cfg, frag, outSeg.meta.timescale); err != nil {
+				return so, fmt.Errorf("addPrft: %w", err)
 			}
 		}
-		outSeg.seg = seg
-		outSeg.data = nil
 	}
 	if isLast && outSeg.seg.Styp != nil {
 		outSeg.seg.Styp.AddCompatibleBrands([]string{"lmsg"})
@@ -113,6 +170,91 @@ func genLiveSegment(log *slog.Logger, vodFS fs.FS, a *asset, cfg *ResponseConfig
 	return outSeg, nil
 }
 
+// terminateAtPeriodEnd handles segments in early-terminated periods.
+// Segments starting after the period end are not found, and a segment crossing
+// the period end is truncated so that it ends at the period end.
+func terminateAtPeriodEnd(cfg *ResponseConfig, so *segOut) error {
+	meta := so.meta
+	startTime := meta.newTime
+	endTime := startTime + uint64(meta.newDur)
+	periodEnd, ok := etpEndTime(cfg, startTime, uint64(meta.timescale))
+	if !ok || endTime <= periodEnd {
+		return nil
+	}
+	if startTime >= periodEnd {
+		return errNotFound
+	}
+	if meta.rep.PreEncrypted {
+		return fmt.Errorf("cannot truncate pre-encrypted segment at early-terminated period end")
+	}
+	err := truncateSegment(meta.rep.initSeg, so.seg, meta, periodEnd)
+	if err != nil {
+		return fmt.Errorf("truncateSegment: %w", err)
+	}
+	so.meta.newDur = uint32(periodEnd - startTime)
+	return nil
+}
+
+// truncateSegment removes all samples with decode time at or after endTime
+// and collects the remaining samples into one fragment.
+func truncateSegment(init *mp4.InitSegment, seg *mp4.MediaSegment, meta segMeta, endTime uint64) error {
+	trex := init.Moov.Mvex.Trex
+	trackID := init.Moov.Trak.Tkhd.TrackID
+	frag, err := mp4.CreateFragment(meta.newNr, trackID)
+	if err != nil {
+		return err
+	}
+	sampleDecodeTime := meta.newTime
+	for _, f := range seg.Fragments {
+		fs, err := f.GetFullSamples(trex)
+		if err != nil {
+			return err
+		}
+		for i := range fs {
+			if sampleDecodeTime >= endTime {
+				break
+			}
+			fs[i].DecodeTime = sampleDecodeTime
+			frag.AddFullSample(fs[i])
+			sampleDecodeTime += uint64(fs[i].Dur)
+		}
+	}
+	seg.Fragments = []*mp4.Fragment{frag}
+	if seg.Sidx != nil && len(seg.Sidx.SidxRefs) > 0 {
+		seg.Sidx.SidxRefs = seg.Sidx.SidxRefs[:1]
+		seg.Sidx.SidxRefs[0].ReferencedSize = uint32(frag.Size())
+		seg.Sidx.SidxRefs[0].SubSegmentDuration = uint32(sampleDecodeTime - meta.newTime)
+	}
+	return nil
+}
+
+// forceTfdtVersion0 changes the tfdt boxes to version 0 with 32-bit baseMediaDecodeTime.
+// The trun data offset and saio offsets are adjusted for the smaller tfdt box.
+func forceTfdtVersion0(frags []*mp4.Fragment) error {
+	for _, frag := range frags {
+		traf := frag.Moof.Traf
+		tfdt := traf.Tfdt
+		if tfdt.Version == 0 {
+			continue
+		}
+		bmdt := tfdt.BaseMediaDecodeTime()
+		if bmdt > math.MaxUint32 {
+			return fmt.Errorf("tfdt_32: baseMediaDecodeTime %d does not fit in 32 bits. Use a later availabilityStartTime", bmdt)
+		}
+		oldTfdtSize := tfdt.Size()
+		tfdt.Version = 0
+		tfdtSizeDiff := int32(tfdt.Size()) - int32(oldTfdtSize)
+		traf.Trun.DataOffset += tfdtSizeDiff
+		frag.Mdat.StartPos += uint64(tfdtSizeDiff)
+		if traf.Saio != nil && saioAfterTfdt(traf) {
+			for i := range traf.Saio.Offset {
+				traf.Saio.Offset[i] += int64(tfdtSizeDiff)
+			}
+		}
+	}
+	return nil
+}
+
 // saioAfterTfdt saio box comes after tfdt in traf
 func saioAfterTfdt(traf *mp4.TrafBox) bool {
 	tfdtIndex := -1
@@ -171,10 +313,14 @@ type segMeta struct {
 // findSegMetaFromTime finds the proper segMeta if media time is OK, or returns error.
 // Time-related errors are TooEarly or Gone.
 // time is measured relative to period start + presentationTimeOffset (PTO).
-// Period start is in turn relative to startTime (availabilityStartTime)
-// For now, period and PTO are both zero, but the startTime may be non-zero.
+// Period start is in turn relative to startTime (availabilityStartTime).
+// A period offset shifts Period@start and PTO by the same amount, so the media time
+// stays relative to startTime. Segments before the period offset are not found.
 func findSegMetaFromTime(a *asset, rep *RepData, time uint64, cfg *ResponseConfig, nowMS int) (segMeta, error) {
-	mediaRef := cfg.StartTimeS * rep.MediaTimescale // TODO. Add period + PTO
+	if beforePeriodOffset(cfg, time, rep.MediaTimescale) {
+		return segMeta{}, errNotFound
+	}
+	mediaRef := cfg.StartTimeS * rep.MediaTimescale
 	wrapDur := a.LoopDurMS * rep.MediaTimescale / 1000
 	nrWraps := int(time) / wrapDur
 	wrapTime := nrWraps * wrapDur
@@ -249,6 +395,9 @@ func findRefSegMetaFromTime(a *asset, rep *RepData, time uint64, cfg *ResponseCo
 	if refEndTime == 0 {
 		return sm, fmt.Errorf("no matching reference segment")
 	}
+	if beforePeriodOffset(cfg, refStartTime, refRep.MediaTimescale) {
+		return sm, errNotFound
+	}
 	dur := uint32(refRep.Segments[relNr].EndTime - refRep.Segments[relNr].StartTime)
 
 	// Check interval validity
@@ -281,7 +430,8 @@ func calcSegmentAvailabilityTime(a *asset, rep *RepData, nr uint32, cfg *Respons
 	wrapDur := a.LoopDurMS * rep.MediaTimescale / 1000
 	wrapTime := nrWraps * wrapDur
 	seg := rep.Segments[relNr]
-	mediaRef := cfg.StartTimeS * rep.MediaTimescale // TODO. Add period offset
+	// Period offset is compensated by PTO, so media times are relative to startTime
+	mediaRef := cfg.StartTimeS * rep.MediaTimescale
 
 	// Check interval validity
 	segAvailTimeS := float64(int(seg.EndTime)+wrapTime+mediaRef) / float64(rep.MediaTimescale)
@@ -305,7 +455,11 @@ func findSegMetaFromNr(a *asset, rep *RepData, nr uint32, cfg *ResponseConfig, n
 	wrapTime := nrWraps * wrapDur
 	seg := rep.Segments[relNr]
 	segTime := wrapTime + int(seg.StartTime)
-	mediaRef := cfg.StartTimeS * rep.MediaTimescale // TODO. Add period offset
+	if beforePeriodOffset(cfg, uint64(segTime), rep.MediaTimescale) {
+		return segMeta{}, errNotFound
+	}
+	// Period offset is compensated by PTO, so media times are relative to startTime
+	mediaRef := cfg.StartTimeS * rep.MediaTimescale
 
 	// Check interval validity
 	segAvailTimeS := float64(int(seg.EndTime)+wrapTime+mediaRef) / float64(rep.MediaTimescale)
@@ -327,6 +481,11 @@ func findSegMetaFromNr(a *asset, rep *RepData, nr uint32, cfg *ResponseConfig, n
 	}, nil
 }
 
+// beforePeriodOffset returns true if media time t is before the start of the first Period.
+func beforePeriodOffset(cfg *ResponseConfig, t uint64, timescale int) bool {
+	return t < uint64(cfg.getPeriodOffsetS()*timescale)
+}
+
 type initMatch struct {
 	isInit bool
 	init   []byte
@@ -416,6 +575,7 @@ func writeLiveSegment(log *slog.Logger, w http.ResponseWriter, cfg *ResponseConf
 	if err != nil {
 		return fmt.Errorf("convertToLive: %w", err)
 	}
+	setCMSDSegmentTiming(w, cfg, outSeg.meta)
 	var data []byte
 	if outSeg.seg != nil {
 		if cfg.DRM != "" {
@@ -425,6 +585,13 @@ func writeLiveSegment(log *slog.Logger, w http.ResponseWriter, cfg *ResponseConf
 				return fmt.Errorf("encryptFrags: %w", err)
 			}
 		}
+		if cfg.SidxFlag {
+			rep := outSeg.meta.rep
+			err = addSidx(outSeg.seg, rep.initSeg, outSeg.meta.timescale)
+			if err != nil {
+				return fmt.Errorf("addSidx: %w", err)
+			}
+		}
 		sw := bits.NewFixedSliceWriter(int(outSeg.seg.Size()))
 		err = outSeg.seg.EncodeSW(sw)
 		if err != nil {
@@ -551,6 +718,9 @@ func createOutSeg(vodFS fs.FS, a *asset, cfg *ResponseConfig, segmentPart string
 	if err != nil {
 		return so, err
 	}
+	if cfg.SegTimelineLossFlag && isLostSegment(a, so.meta.newTime, so.meta.timescale) {
+		return so, errNotFound
+	}
 	segPath := path.Join(a.AssetPath, replaceTimeAndNr(rep.MediaURI, so.meta.origTime, so.meta.origNr))
 	so.data, err = fs.ReadFile(vodFS, segPath)
 	if err != nil {
@@ -589,6 +759,9 @@ func findSegMeta(a *asset, cfg *ResponseConfig, segmentPart string, nowMS int) (
 		if err != nil {
 			return sm, err
 		}
+		if cfg.SegTimelineLossFlag && isLostSegment(a, sm.newTime, sm.timescale) {
+			return sm, errNotFound
+		}
 	}
 	return sm, nil
 }
@@ -640,6 +813,9 @@ func findRefSegMeta(a *asset, cfg *ResponseConfig, segmentPart string, nowMS int
 		if err != nil {
 			return refMeta, fmt.Errorf("findSegMetaFromNr from reference: %w", err)
 		}
+		if cfg.SegTimelineLossFlag && isLostSegment(a, refMeta.newTime, refMeta.timescale) {
+			return refMeta, errNotFound
+		}
 	default:
 		return refMeta, fmt.Errorf("unknown liveMPD type")
 	}
@@ -662,6 +838,7 @@ func writeChunkedSegment(ctx context.Context, log *slog.Logger, w http.ResponseW
 	if so.seg == nil {
 		return fmt.Errorf("no segment data for chunked segment")
 	}
+	setCMSDSegmentTiming(w, cfg, so.meta)
 
 	w.Header().Set("Content-Type", so.meta.rep.SegmentType())
 	if isImage(segmentPart) {
@@ -670,26 +847,12 @@ func writeChunkedSegment(ctx context.Context, log *slog.Logger, w http.ResponseW
 		return fmt.Errorf("could not write image segment: %w", err)
 	}
 	rep := so.meta.rep
-	seg := so.seg
 
 	// Some part of the segment should be available, and is delivered directly.
 	// The rest are returned HTTP chunks as time passes.
-	// In general, we should extract all the samples and build a new one with the right fragment duration.
-	// That fragment/chunk duration is segment_duration-availabilityTimeOffset.
-	chunkDur := (a.SegmentDurMS - int(cfg.AvailabilityTimeOffsetS*1000)) * int(rep.MediaTimescale) / 1000
-	chunks, err := chunkSegment(rep.initSeg, seg, so.meta, chunkDur)
+	chunks, err := createChunks(log, cfg, drmCfg, a, so)
 	if err != nil {
-		return fmt.Errorf("chunkSegment: %w", err)
-	}
-	if cfg.DRM != "" {
-		frags := make([]*mp4.Fragment, len(chunks))
-		for i, chk := range chunks {
-			frags[i] = chk.frag
-		}
-		err := encryptFrags(log, cfg, drmCfg, rep, frags)
-		if err != nil {
-			return fmt.Errorf("encryptFrags: %w", err)
-		}
+		return err
 	}
 
 	startUnixMS := unixMS()
@@ -725,12 +888,154 @@ func writeChunkedSegment(ctx context.Context, log *slog.Logger, w http.ResponseW
 	return nil
 }
 
+// chunkDuration returns the chunk duration in timescale units for low-latency mode.
+// The chunk duration is given by chunkdur if set, and segment_duration-availabilityTimeOffset otherwise.
+func chunkDuration(a *asset, cfg *ResponseConfig, timescale int) int {
+	if cfg.ChunkDurS != nil {
+		return int(math.Round(*cfg.ChunkDurS * float64(timescale)))
+	}
+	return (a.SegmentDurMS - int(cfg.AvailabilityTimeOffsetS*1000)) * timescale / 1000
+}
+
+// createChunks splits a generated segment into chunks, and applies tfdt, encryption and sidx
+// configuration to the chunks.
+// Each track is chunked independently with chunk boundaries at the nearest sample boundary.
+func createChunks(log *slog.Logger, cfg *ResponseConfig, drmCfg *drm.DrmConfig, a *asset, so segOut) ([]chunk, error) {
+	rep := so.meta.rep
+	chunkDur := chunkDuration(a, cfg, int(rep.MediaTimescale))
+	chunks, err := chunkSegment(rep.initSeg, so.seg, so.meta, chunkDur)
+	if err != nil {
+		return nil, fmt.Errorf("chunkSegment: %w", err)
+	}
+	err = finalizeChunks(log, cfg, drmCfg, so, chunks)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.SidxFlag && len(chunks) > 0 {
+		frags := make([]*mp4.Fragment, len(chunks))
+		for i, chk := range chunks {
+			frags[i] = chk.frag
+		}
+		chunks[0].sidx, err = createSidx(frags, rep.initSeg, so.meta.timescale)
+		if err != nil {
+			return nil, fmt.Errorf("createSidx: %w", err)
+		}
+	}
+	return chunks, nil
+}
+
+// finalizeChunks applies tfdt, prft and encryption configuration to the chunks.
+func finalizeChunks(log *slog.Logger, cfg *ResponseConfig, drmCfg *drm.DrmConfig, so segOut, chunks []chunk) error {
+	var err error
+	if cfg.Tfdt32Flag {
+		for _, chk := range chunks {
+			err = forceTfdtVersion0([]*mp4.Fragment{chk.frag})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if cfg.PrftDelayMS != nil {
+		for _, chk := range chunks {
+			if err := addPrft(cfg, chk.frag, so.meta.timescale); err != nil {
+				return fmt.Errorf("addPrft: %w", err)
+			}
+		}
+	}
+	if cfg.DRM != "" {
+		frags := make([]*mp4.Fragment, len(chunks))
+		for i, chk := range chunks {
+			frags[i] = chk.frag
+		}
+		err = encryptFrags(log, cfg, drmCfg, so.meta.rep, frags)
+		if err != nil {
+			return fmt.Errorf("encryptFrags: %w", err)
+		}
+	}
+	return nil
+}
+
+// writePartSegment writes a single chunk of a segment as an LL-HLS partial segment.
+// A request for a part that is not yet available is held until the part is available,
+// provided that the wait is shorter than a segment duration.
+// Only the requested chunk gets tfdt, prft and encryption configuration applied.
+func writePartSegment(ctx context.Context, log *slog.Logger, w http.ResponseWriter, cfg *ResponseConfig, drmCfg *drm.DrmConfig,
+	vodFS fs.FS, a *asset, segmentPart string, partNr int, nowMS int) error {
+	log.Debug("writePartSegment", "segmentPart", segmentPart, "part", partNr)
+	if err := cfg.setAtoFromChunkDur(a.SegmentDurMS); err != nil {
+		return err
+	}
+	maxWaitMS := a.SegmentDurMS
+	so, err := genLiveSegment(log, vodFS, a, cfg, segmentPart, nowMS, false /* isLast */)
+	var tooEarly errTooEarly
+	if errors.As(err, &tooEarly) && tooEarly.deltaMS <= maxWaitMS {
+		if err := sleepCtx(ctx, time.Duration(tooEarly.deltaMS)*time.Millisecond); err != nil {
+			return err
+		}
+		nowMS += tooEarly.deltaMS
+		so, err = genLiveSegment(log, vodFS, a, cfg, segmentPart, nowMS, false /* isLast */)
+	}
+	if err != nil {
+		return fmt.Errorf("convertToLive: %w", err)
+	}
+	if so.seg == nil {
+		return fmt.Errorf("no segment data for part")
+	}
+	rep := so.meta.rep
+	chunks, err := chunkSegment(rep.initSeg, so.seg, so.meta, chunkDuration(a, cfg, int(rep.MediaTimescale)))
+	if err != nil {
+		return fmt.Errorf("chunkSegment: %w", err)
+	}
+	if partNr < 0 || partNr >= len(chunks) {
+		return fmt.Errorf("part %d of %s: %w", partNr, segmentPart, errNotFound)
+	}
+	timescale := int(so.meta.rep.MediaTimescale)
+	partEnd := int(so.meta.newTime) + cfg.StartTimeS*timescale
+	for _, chk := range chunks[:partNr+1] {
+		partEnd += int(chk.dur)
+	}
+	partAvailMS := partEnd * 1000 / timescale
+	if partAvailMS > nowMS {
+		if partAvailMS-nowMS > maxWaitMS {
+			return newErrTooEarly(partAvailMS - nowMS)
+		}
+		if err := sleepCtx(ctx, time.Duration(partAvailMS-nowMS)*time.Millisecond); err != nil {
+			return err
+		}
+	}
+	part := chunks[partNr : partNr+1]
+	err = finalizeChunks(log, cfg, drmCfg, so, part)
+	if err != nil {
+		return err
+	}
+	setCMSDSegmentTiming(w, cfg, so.meta)
+	w.Header().Set("Content-Type", rep.SegmentType())
+	err = writeChunk(w, part[0])
+	if err != nil {
+		return fmt.Errorf("writeChunk: %w", err)
+	}
+	return nil
+}
+
 func unixMS() int {
 	return int(time.Now().UnixMilli())
 }
 
+// sleepCtx waits for duration d, but returns the context error if ctx is done before that.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	select {
+	case <-ctx.Done():
+		timer.Stop()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
 type chunk struct {
 	styp *mp4.StypBox
+	sidx *mp4.SidxBox
 	frag *mp4.Fragment
 	dur  uint64 // in media timescale
 }
@@ -744,7 +1049,39 @@ func createChunk(styp *mp4.StypBox, trackID, seqNr uint32) chunk {
 	}
 }
 
-// chunkSegment splits a segment into chunks of specified duration.
+// chunkSampleCounts returns the number of samples in each chunk when samples with
+// durations sampleDurs are split into chunks of duration chunkDur.
+// Every chunk ends at the sample boundary closest to a multiple of chunkDur,
+// but contains at least one sample.
+func chunkSampleCounts(sampleDurs []uint32, chunkDur int) []int {
+	counts := make([]int, 0, 8)
+	chunkNr := 1
+	totalDur := 0
+	nrSamples := 0
+	for i, dur := range sampleDurs {
+		totalDur += int(dur)
+		nrSamples++
+		if i == len(sampleDurs)-1 {
+			break
+		}
+		// End chunk if the next sample ends further from the chunk end than this one
+		nextEnd := totalDur + int(sampleDurs[i+1])
+		if nextEnd-chunkDur*chunkNr > chunkDur*chunkNr-totalDur {
+			counts = append(counts, nrSamples)
+			nrSamples = 0
+			chunkNr++
+			for chunkDur*chunkNr <= totalDur {
+				chunkNr++
+			}
+		}
+	}
+	if nrSamples > 0 {
+		counts = append(counts, nrSamples)
+	}
+	return counts
+}
+
+// chunkSegment splits a segment into chunks of specified duration as given by chunkSampleCounts.
 // The first chunk gets an styp box if one is available in the incoming segment.
 func chunkSegment(init *mp4.InitSegment, seg *mp4.MediaSegment, segMeta segMeta, chunkDur int) ([]chunk, error) {
 	trex := init.Moov.Mvex.Trex
@@ -756,32 +1093,29 @@ func chunkSegment(init *mp4.InitSegment, seg *mp4.MediaSegment, segMeta segMeta,
 		}
 		fs = append(fs, ff...)
 	}
-	chunks := make([]chunk, 0, segMeta.newDur/uint32(chunkDur))
+	if chunkDur <= 0 {
+		return nil, fmt.Errorf("chunk duration %d must be positive", chunkDur)
+	}
+	sampleDurs := make([]uint32, len(fs))
+	for i := range fs {
+		sampleDurs[i] = fs[i].Dur
+	}
+	counts := chunkSampleCounts(sampleDurs, chunkDur)
+	chunks := make([]chunk, 0, len(counts))
 	trackID := init.Moov.Trak.Tkhd.TrackID
-	ch := createChunk(seg.Styp, trackID, segMeta.newNr)
-	chunkNr := 1
-	var accChunkDur uint32 = 0
-	var totalDur int = 0
+	styp := seg.Styp
 	sampleDecodeTime := segMeta.newTime
-	var thisChunkDur uint32 = 0
-	for i := range fs {
-		fs[i].DecodeTime = sampleDecodeTime
-		ch.frag.AddFullSample(fs[i])
-		dur := fs[i].Dur
-		sampleDecodeTime += uint64(dur)
-		accChunkDur += dur
-		thisChunkDur += dur
-		totalDur += int(dur)
-		if totalDur >= chunkDur*chunkNr {
-			ch.dur = uint64(thisChunkDur)
-			chunks = append(chunks, ch)
-			ch = createChunk(nil, trackID, segMeta.newNr)
-			thisChunkDur = 0
-			chunkNr++
+	sampleNr := 0
+	for _, count := range counts {
+		ch := createChunk(styp, trackID, segMeta.newNr)
+		styp = nil
+		for i := sampleNr; i < sampleNr+count; i++ {
+			fs[i].DecodeTime = sampleDecodeTime
+			ch.frag.AddFullSample(fs[i])
+			sampleDecodeTime += uint64(fs[i].Dur)
+			ch.dur += uint64(fs[i].Dur)
 		}
-	}
-	if thisChunkDur > 0 {
-		ch.dur = uint64(chunkDur)
+		sampleNr += count
 		chunks = append(chunks, ch)
 	}
 
@@ -795,6 +1129,12 @@ func writeChunk(w http.ResponseWriter, chk chunk) error {
 			return err
 		}
 	}
+	if chk.sidx != nil {
+		err := chk.sidx.Encode(w)
+		if err != nil {
+			return err
+		}
+	}
 	err := chk.frag.Encode(w)
 	if err != nil {
 		return err
@@ -804,6 +1144,70 @@ func writeChunk(w http.ResponseWriter, chk chunk) error {
 	return nil
 }
 
+// addSidx replaces any sidx box in seg with a new one referencing all fragments.
+// It must be called after all changes to the fragments, since the referenced sizes are the encoded fragment sizes.
+func addSidx(seg *mp4.MediaSegment, init *mp4.InitSegment, timescale uint32) error {
+	sidx, err := createSidx(seg.Fragments, init, timescale)
+	if err != nil {
+		return err
+	}
+	seg.Sidx = sidx
+	seg.Sidxs = []*mp4.SidxBox{sidx}
+	return nil
+}
+
+// createSidx creates a sidx box with one reference per fragment.
+// A segment with a single fragment results in a single reference.
+func createSidx(frags []*mp4.Fragment, init *mp4.InitSegment, timescale uint32) (*mp4.SidxBox, error) {
+	var trex *mp4.TrexBox
+	var trackID uint32 = 1
+	if init != nil {
+		trex = init.Moov.Mvex.Trex
+		trackID = init.Moov.Trak.Tkhd.TrackID
+	}
+	sidx := &mp4.SidxBox{
+		ReferenceID: trackID,
+		Timescale:   timescale,
+	}
+	for i, frag := range frags {
+		samples, err := frag.GetFullSamples(trex)
+		if err != nil {
+			return nil, fmt.Errorf("getFullSamples: %w", err)
+		}
+		if len(samples) == 0 {
+			return nil, fmt.Errorf("no samples in fragment %d", i)
+		}
+		ept := int64(samples[0].DecodeTime) + int64(samples[0].CompositionTimeOffset)
+		var dur uint64
+		for _, s := range samples {
+			ept = min(ept, int64(s.DecodeTime)+int64(s.CompositionTimeOffset))
+			dur += uint64(s.Dur)
+		}
+		if i == 0 {
+			sidx.EarliestPresentationTime = uint64(max(ept, 0))
+			if sidx.EarliestPresentationTime > math.MaxUint32 {
+				sidx.Version = 1
+			}
+		}
+		// The encoded size is used, since encoding may optimize the trun box
+		buf := bytes.Buffer{}
+		err = frag.Encode(&buf)
+		if err != nil {
+			return nil, fmt.Errorf("encode fragment: %w", err)
+		}
+		ref := mp4.SidxRef{
+			ReferencedSize:     uint32(buf.Len()),
+			SubSegmentDuration: uint32(dur),
+		}
+		if samples[0].IsSync() {
+			ref.StartsWithSAP = 1
+			ref.SAPType = 1
+		}
+		sidx.SidxRefs = append(sidx.SidxRefs, ref)
+	}
+	return sidx, nil
+}
+
 // Ptr returns a pointer to a value of any type
 func Ptr[T any](v T) *T {
 	return &v
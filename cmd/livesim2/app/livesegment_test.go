@@ -15,6 +15,7 @@ import (
 	"path"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/Dash-Industry-Forum/livesim2/pkg/drm"
 	"github.com/Dash-Industry-Forum/livesim2/pkg/logging"
@@ -289,6 +290,73 @@ func TestWriteChunkedSegment(t *testing.T) {
 	}
 }
 
+func TestChunkDurations(t *testing.T) {
+	vodFS := os.DirFS("testdata/assets")
+	am := newAssetMgr(vodFS, "", false)
+	log := slog.Default()
+	err := am.discoverAssets(log)
+	require.NoError(t, err)
+	asset, ok := am.findAsset("testpic_2s")
+	require.True(t, ok)
+
+	cases := []struct {
+		desc       string
+		media      string
+		chunkDurS  float64 // 0 means single-sample chunks
+		wantedAtoS float64
+	}{
+		{desc: "video 0.5s", media: "V300/10.m4s", chunkDurS: 0.5, wantedAtoS: 1.5},
+		{desc: "audio 0.5s", media: "A48/10.m4s", chunkDurS: 0.5, wantedAtoS: 1.5},
+		{desc: "video 0.3s", media: "V300/10.m4s", chunkDurS: 0.3, wantedAtoS: 1.7},
+		{desc: "audio 0.3s", media: "A48/10.m4s", chunkDurS: 0.3, wantedAtoS: 1.7},
+		{desc: "video single frame", media: "V300/10.m4s"},
+		{desc: "audio single frame", media: "A48/10.m4s"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			cfg := NewResponseConfig()
+			so, err := genLiveSegment(log, vodFS, asset, cfg, tc.media, 30_000, false /* isLast */)
+			require.NoError(t, err)
+			trex := so.meta.rep.initSeg.Moov.Mvex.Trex
+			samples, err := so.seg.Fragments[0].GetFullSamples(trex)
+			require.NoError(t, err)
+			sampleDur := int(samples[0].Dur)
+			timescale := int(so.meta.rep.MediaTimescale)
+			chunkDur := int(math.Round(tc.chunkDurS * float64(timescale)))
+			if tc.chunkDurS == 0 {
+				chunkDur = sampleDur
+			} else {
+				cfg.ChunkDurS = Ptr(tc.chunkDurS)
+				require.NoError(t, cfg.setAtoFromChunkDur(asset.SegmentDurMS))
+				require.InDelta(t, tc.wantedAtoS, cfg.AvailabilityTimeOffsetS, 0.0001)
+			}
+			chunks, err := chunkSegment(so.meta.rep.initSeg, so.seg, so.meta, chunkDur)
+			require.NoError(t, err)
+			end := 0
+			for i, chk := range chunks {
+				end += int(chk.dur)
+				if i == len(chunks)-1 {
+					break
+				}
+				nearest := int(math.Round(float64(end)/float64(chunkDur))) * chunkDur
+				require.InDelta(t, nearest, end, float64(sampleDur)/2, "chunk %d ends at %d", i, end)
+			}
+			require.Equal(t, int(so.meta.newDur), end)
+			if tc.chunkDurS == 0 {
+				require.Equal(t, len(samples), len(chunks))
+			}
+		})
+	}
+
+	cfg := NewResponseConfig()
+	cfg.ChunkDurS = Ptr(2.5)
+	require.Error(t, cfg.setAtoFromChunkDur(asset.SegmentDurMS))
+	cfg.ChunkDurS = Ptr(0.5)
+	cfg.AvailabilityTimeOffsetS = 1.0
+	require.NoError(t, cfg.setAtoFromChunkDur(asset.SegmentDurMS))
+	require.Equal(t, 1.0, cfg.AvailabilityTimeOffsetS, "explicit ato should be kept")
+}
+
 func TestAvailabilityTime(t *testing.T) {
 	testCases := []struct {
 		desc       string
@@ -728,3 +796,223 @@ func TestMehdBoxRemovedFromInitSegment(t *testing.T) {
 	require.NotNil(t, initSeg)
 	require.Nil(t, initSeg.Moov.Mvex.Mehd)
 }
+
+func TestEarlyTerminatedPeriodSegments(t *testing.T) {
+	vodFS := os.DirFS("testdata/assets")
+	am := newAssetMgr(vodFS, "", false)
+	log := slog.Default()
+	err := am.discoverAssets(log)
+	require.NoError(t, err)
+	asset, ok := am.findAsset("testpic_2s")
+	require.True(t, ok)
+	nowMS := 1001_000
+	cfg, err := processURLCfg("/livesim2/periods_60/etp_30/etpDuration_31/testpic_2s/Manifest.mpd", nowMS)
+	require.NoError(t, err)
+
+	cases := []struct {
+		media     string
+		wantedErr error
+		minDur    uint64
+		maxDur    uint64
+	}{
+		{media: "V300/494.m4s", minDur: 2 * 90000, maxDur: 2 * 90000},
+		{media: "V300/495.m4s", minDur: 90000, maxDur: 90000},
+		{media: "V300/496.m4s", wantedErr: errNotFound},
+		{media: "A48/495.m4s", minDur: 48000 - 1024, maxDur: 48000 + 1024},
+		{media: "A48/496.m4s", wantedErr: errNotFound},
+	}
+	for _, tc := range cases {
+		so, err := genLiveSegment(log, vodFS, asset, cfg, tc.media, nowMS, false /*isLast */)
+		if tc.wantedErr != nil {
+			require.ErrorIs(t, err, tc.wantedErr, tc.media)
+			continue
+		}
+		require.NoError(t, err, tc.media)
+		require.Equal(t, 1, len(so.seg.Fragments), tc.media)
+		trex := so.meta.rep.initSeg.Moov.Mvex.Trex
+		var dur uint64
+		for _, f := range so.seg.Fragments {
+			samples, err := f.GetFullSamples(trex)
+			require.NoError(t, err)
+			for _, s := range samples {
+				dur += uint64(s.Dur)
+			}
+		}
+		require.GreaterOrEqual(t, dur, tc.minDur, tc.media)
+		require.LessOrEqual(t, dur, tc.maxDur, tc.media)
+		// The low-latency chunks are made from the truncated segment
+		chunks, err := chunkSegment(so.meta.rep.initSeg, so.seg, so.meta, int(so.meta.timescale)/2)
+		require.NoError(t, err, tc.media)
+		var chunksDur uint64
+		for _, chk := range chunks {
+			chunksDur += chk.dur
+		}
+		require.Equal(t, dur, chunksDur, tc.media)
+	}
+}
+
+func TestVerifyAssetConfig(t *testing.T) {
+	vodFS := os.DirFS("testdata/assets")
+	am := newAssetMgr(vodFS, "", false)
+	err := am.discoverAssets(slog.Default())
+	require.NoError(t, err)
+	asset, ok := am.findAsset("testpic_2s")
+	require.True(t, ok)
+	cfg, err := processURLCfg("/livesim2/periods_60/etp_30/testpic_2s/Manifest.mpd", 1001_000)
+	require.NoError(t, err)
+	require.NoError(t, verifyAssetConfig(cfg, asset))
+	for _, rep := range asset.Reps {
+		if rep.ContentType == "video" {
+			rep.PreEncrypted = true
+		}
+	}
+	require.EqualError(t, verifyAssetConfig(cfg, asset), "etp not supported for pre-encrypted content")
+}
+
+func TestPeriodOffsetSegments(t *testing.T) {
+	vodFS := os.DirFS("testdata/assets")
+	am := newAssetMgr(vodFS, "", false)
+	log := slog.Default()
+	err := am.discoverAssets(log)
+	require.NoError(t, err)
+	asset, ok := am.findAsset("testpic_2s")
+	require.True(t, ok)
+	nowMS := 50_000
+
+	cases := []struct {
+		url       string
+		media     string
+		wantedErr error
+	}{
+		{url: "/livesim2/peroff_10/testpic_2s/Manifest.mpd", media: "V300/4.m4s", wantedErr: errNotFound},
+		{url: "/livesim2/peroff_10/testpic_2s/Manifest.mpd", media: "V300/5.m4s"},
+		{url: "/livesim2/peroff_10/testpic_2s/Manifest.mpd", media: "A48/4.m4s", wantedErr: errNotFound},
+		{url: "/livesim2/peroff_10/testpic_2s/Manifest.mpd", media: "A48/5.m4s"},
+		{url: "/livesim2/peroff_10/segtimeline_1/testpic_2s/Manifest.mpd", media: "V300/720000.m4s", wantedErr: errNotFound},
+		{url: "/livesim2/peroff_10/segtimeline_1/testpic_2s/Manifest.mpd", media: "V300/900000.m4s"},
+	}
+	for _, tc := range cases {
+		cfg, err := processURLCfg(tc.url, nowMS)
+		require.NoError(t, err)
+		_, err = genLiveSegment(log, vodFS, asset, cfg, tc.media, nowMS, false /*isLast */)
+		if tc.wantedErr != nil {
+			require.ErrorIs(t, err, tc.wantedErr, tc.media)
+			continue
+		}
+		require.NoError(t, err, tc.media)
+	}
+}
+
+func TestTfdt32(t *testing.T) {
+	vodFS := os.DirFS("testdata/assets")
+	am := newAssetMgr(vodFS, "", false)
+	log := slog.Default()
+	err := am.discoverAssets(log)
+	require.NoError(t, err)
+	asset, ok := am.findAsset("testpic_2s")
+	require.True(t, ok)
+	nowMS := 100_000
+
+	for _, media := range []string{"V300/40.m4s", "A48/40.m4s"} {
+		t.Run(media, func(t *testing.T) {
+			cfg, err := processURLCfg("/livesim2/testpic_2s/Manifest.mpd", nowMS)
+			require.NoError(t, err)
+			refSo, err := genLiveSegment(log, vodFS, asset, cfg, media, nowMS, false /*isLast */)
+			require.NoError(t, err)
+			trex := refSo.meta.rep.initSeg.Moov.Mvex.Trex
+			refSamples, err := refSo.seg.Fragments[0].GetFullSamples(trex)
+			require.NoError(t, err)
+
+			cfg, err = processURLCfg("/livesim2/tfdt_32/testpic_2s/Manifest.mpd", nowMS)
+			require.NoError(t, err)
+			so, err := genLiveSegment(log, vodFS, asset, cfg, media, nowMS, false /*isLast */)
+			require.NoError(t, err)
+			sw := bits.NewFixedSliceWriter(int(so.seg.Size()))
+			err = so.seg.EncodeSW(sw)
+			require.NoError(t, err)
+			decFile, err := mp4.DecodeFileSR(bits.NewFixedSliceReader(sw.Bytes()))
+			require.NoError(t, err)
+			frag := decFile.Segments[0].Fragments[0]
+			tfdt := frag.Moof.Traf.Tfdt
+			require.Equal(t, byte(0), tfdt.Version)
+			require.Equal(t, refSo.meta.newTime, tfdt.BaseMediaDecodeTime())
+			samples, err := frag.GetFullSamples(trex)
+			require.NoError(t, err)
+			require.Equal(t, len(refSamples), len(samples))
+			for i := range samples {
+				require.Equal(t, refSamples[i].Data, samples[i].Data, "sample %d", i)
+			}
+		})
+	}
+}
+
+func TestSidx(t *testing.T) {
+	vodFS := os.DirFS("testdata/assets")
+	am := newAssetMgr(vodFS, "", false)
+	log := slog.Default()
+	err := am.discoverAssets(log)
+	require.NoError(t, err)
+
+	cases := []struct {
+		desc         string
+		asset        string
+		media        string
+		chunked      bool
+		nowMS        int
+		wantedEPT    uint64
+		wantedNrRefs int
+	}{
+		{desc: "video", asset: "testpic_2s", media: "V300/40.m4s", nowMS: 100_000, wantedEPT: 80 * 90000, wantedNrRefs: 1},
+		{desc: "audio", asset: "testpic_2s", media: "A48/40.m4s", nowMS: 100_000, wantedEPT: 80 * 48000, wantedNrRefs: 1},
+		{desc: "chunked video", asset: "testpic_8s", media: "V300/10.m4s", chunked: true, nowMS: 86_000,
+			wantedEPT: 80 * 15360, wantedNrRefs: 8},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			asset, ok := am.findAsset(tc.asset)
+			require.True(t, ok)
+			cfg := NewResponseConfig()
+			cfg.SidxFlag = true
+			rr := httptest.NewRecorder()
+			if tc.chunked {
+				cfg.AvailabilityTimeCompleteFlag = false
+				cfg.AvailabilityTimeOffsetS = 7.0
+				err = writeChunkedSegment(context.Background(), log, rr, cfg, nil, vodFS, asset, tc.media, tc.nowMS, false /* isLast */)
+			} else {
+				err = writeLiveSegment(log, rr, cfg, nil, vodFS, asset, tc.media, tc.nowMS, nil, false /* isLast */)
+			}
+			require.NoError(t, err)
+			data := rr.Body.Bytes()
+			mp4d, err := mp4.DecodeFileSR(bits.NewFixedSliceReader(data))
+			require.NoError(t, err)
+			seg := mp4d.Segments[0]
+			require.NotNil(t, seg.Sidx)
+			// Earliest presentation time may be after decode time due to composition time offsets
+			require.GreaterOrEqual(t, seg.Sidx.EarliestPresentationTime, tc.wantedEPT)
+			require.Less(t, seg.Sidx.EarliestPresentationTime, tc.wantedEPT+uint64(seg.Sidx.Timescale/2))
+			require.Equal(t, tc.wantedNrRefs, len(seg.Sidx.SidxRefs))
+			require.Equal(t, len(seg.Fragments), len(seg.Sidx.SidxRefs))
+			var refSize int
+			for i, ref := range seg.Sidx.SidxRefs {
+				require.Equal(t, seg.Fragments[i].Size(), uint64(ref.ReferencedSize), "ref %d", i)
+				refSize += int(ref.ReferencedSize)
+			}
+			headerSize := 0
+			if seg.Styp != nil {
+				headerSize += int(seg.Styp.Size())
+			}
+			headerSize += int(seg.Sidx.Size())
+			require.Equal(t, len(data), headerSize+refSize)
+		})
+	}
+}
+
+func TestSleepCtx(t *testing.T) {
+	require.NoError(t, sleepCtx(context.Background(), time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	err := sleepCtx(ctx, time.Hour)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Less(t, time.Since(start), time.Second)
+}
@@ -16,6 +16,7 @@ func addVersionAndCORSHeaders(next http.Handler) http.Handler {
 		w.Header().Add("Access-Control-Allow-Origin", "*")
 		w.Header().Add("Access-Control-Allow-Methods", "POST, GET, HEAD, OPTIONS")
 		w.Header().Add("Access-Control-Allow-Headers", "Content-Type, Accept")
+		w.Header().Add("Access-Control-Expose-Headers", "CMSD-Static, CMSD-Dynamic")
 		w.Header().Add("Timing-Allow-Origin", "*")
 		next.ServeHTTP(w, r)
 	}
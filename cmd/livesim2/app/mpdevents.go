@@ -0,0 +1,51 @@
+// Copyright 2023, DASH-Industry Forum. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.md file.
+
+package app
+
+import (
+	"math"
+	"time"
+
+	m "github.com/Eyevinn/dash-mpd/mpd"
+)
+
+// mpdEventWindowMS returns the interval for MPD events at nowMS relative to availabilityStartTime.
+// It covers the time-shift buffer and leadMS after now, but does not extend beyond the stop time.
+func mpdEventWindowMS(cfg *ResponseConfig, nowMS, leadMS int) (startMS, endMS int) {
+	astMS := cfg.StartTimeS * 1000
+	tsbdS := defaultTimeShiftBufferDepthS
+	if cfg.TimeShiftBufferDepthS != nil {
+		tsbdS = *cfg.TimeShiftBufferDepthS
+	}
+	startMS = max(nowMS-astMS-tsbdS*1000, 0)
+	endMS = nowMS - astMS + leadMS
+	if cfg.StopTimeS != nil {
+		endMS = min(endMS, (*cfg.StopTimeS-cfg.StartTimeS)*1000)
+	}
+	return startMS, max(endMS, startMS)
+}
+
+// periodItvlMS returns the interval [startMS, endMS) of Period number i relative to
+// availabilityStartTime. The end is the start of the next Period.
+func periodItvlMS(periods []*m.Period, i int) (startMS, endMS uint64) {
+	if p := periods[i]; p.Start != nil {
+		startMS = uint64(time.Duration(*p.Start).Milliseconds())
+	}
+	endMS = math.MaxUint64
+	if i+1 < len(periods) && periods[i+1].Start != nil {
+		endMS = uint64(time.Duration(*periods[i+1].Start).Milliseconds())
+	}
+	return startMS, endMS
+}
+
+// videoTimescale returns the media timescale of the first video representation, or 0 if there is none.
+func videoTimescale(a *asset) uint64 {
+	for _, rep := range a.Reps {
+		if rep.ContentType == "video" {
+			return uint64(rep.MediaTimescale)
+		}
+	}
+	return 0
+}
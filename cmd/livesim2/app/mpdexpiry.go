@@ -0,0 +1,78 @@
+// Copyright 2023, DASH-Industry Forum. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.md file.
+
+package app
+
+import (
+	"sort"
+
+	m "github.com/Eyevinn/dash-mpd/mpd"
+	"github.com/Eyevinn/mp4ff/mp4"
+)
+
+const (
+	mpdEventSchemeIDURI = "urn:mpeg:dash:event:2012"
+	// mpdValidityExpiration is the value of an MPD validity expiration event.
+	mpdValidityExpiration = "1"
+)
+
+// mpdChangeTimesMS returns the times in (fromMS, toMS] when the MPD changes structurally,
+// i.e. when a new Period starts or the stop time is reached.
+// All times are relative to availabilityStartTime.
+func mpdChangeTimesMS(cfg *ResponseConfig, fromMS, toMS int) []int {
+	var times []int
+	inRange := func(tMS int) bool { return fromMS < tMS && tMS <= toMS }
+	switch {
+	case cfg.InsertAdFlag && cfg.SCTE35 != nil:
+		for _, itvl := range adInsertionItvls(*cfg.SCTE35, fromMS/1000, toMS/1000+1) {
+			if inRange(itvl.startS * 1000) {
+				times = append(times, itvl.startS*1000)
+			}
+		}
+	case cfg.PeriodsPerHour != nil || len(cfg.PeriodDurations) > 0:
+		periodDurs := cfg.PeriodDurations
+		if cfg.PeriodsPerHour != nil {
+			periodDurs = []int{3600 / *cfg.PeriodsPerHour}
+		}
+		offsetS := cfg.getPeriodOffsetS()
+		for _, itvl := range multiPeriodItvls(periodDurs, offsetS, fromMS, toMS) {
+			// The first period is in the MPD from the start
+			if itvl.startS > offsetS && inRange(itvl.startS*1000) {
+				times = append(times, itvl.startS*1000)
+			}
+		}
+	}
+	if cfg.StopTimeS != nil {
+		stopMS := (*cfg.StopTimeS - cfg.StartTimeS) * 1000
+		if inRange(stopMS) {
+			times = append(times, stopMS)
+		}
+	}
+	sort.Ints(times)
+	return times
+}
+
+// mpdExpiryEmsgs returns MPD validity expiration emsg boxes for a segment [segStart, segEnd)
+// in timescale units. A segment carries the event of every MPD change in the cfg.MPDExpiryLeadS
+// seconds after its start, but not of a change at its start.
+// The event ID is the change time in seconds and the message data is the publishTime of the new MPD.
+func mpdExpiryEmsgs(cfg *ResponseConfig, segStart, segEnd, timescale uint64) []*mp4.EmsgBox {
+	segStartMS := int(segStart * 1000 / timescale)
+	segEndMS := int(segEnd * 1000 / timescale)
+	changeTimesMS := mpdChangeTimesMS(cfg, segStartMS, segEndMS+*cfg.MPDExpiryLeadS*1000-1)
+	emsgs := make([]*mp4.EmsgBox, 0, len(changeTimesMS))
+	for _, tMS := range changeTimesMS {
+		publishTime := m.ConvertToDateTimeMS(int64(cfg.StartTimeS*1000 + tMS))
+		emsgs = append(emsgs, &mp4.EmsgBox{
+			Version:          1,
+			TimeScale:        uint32(timescale),
+			PresentationTime: uint64(tMS) * timescale / 1000,
+			ID:               uint32(tMS / 1000),
+			SchemeIDURI:      mpdEventSchemeIDURI,
+			Value:            mpdValidityExpiration,
+			MessageData:      []byte(publishTime),
+		})
+	}
+	return emsgs
+}
@@ -0,0 +1,96 @@
+// Copyright 2023, DASH-Industry Forum. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.md file.
+
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Dash-Industry-Forum/livesim2/pkg/logging"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMPDChangeTimes(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		url         string
+		fromMS      int
+		toMS        int
+		wantedTimes []int
+	}{
+		{desc: "period start", url: "/livesim2/periods_60/testpic_2s/Manifest.mpd", fromMS: 50_000, toMS: 70_000,
+			wantedTimes: []int{60_000}},
+		{desc: "period start excluded", url: "/livesim2/periods_60/testpic_2s/Manifest.mpd", fromMS: 60_000, toMS: 70_000},
+		{desc: "first period", url: "/livesim2/periods_60/peroff_20/testpic_2s/Manifest.mpd", fromMS: 0, toMS: 30_000},
+		{desc: "period cycle", url: "/livesim2/dur_20/dur_40/testpic_2s/Manifest.mpd", fromMS: 0, toMS: 100_000,
+			wantedTimes: []int{20_000, 60_000, 80_000}},
+		{desc: "ad breaks", url: "/livesim2/insertad_1/scte35_1/testpic_2s/Manifest.mpd", fromMS: 0, toMS: 40_000,
+			wantedTimes: []int{10_000, 30_000}},
+		{desc: "stop", url: "/livesim2/start_10/stop_110/testpic_2s/Manifest.mpd", fromMS: 90_000, toMS: 100_000,
+			wantedTimes: []int{100_000}},
+		{desc: "no changes", url: "/livesim2/testpic_2s/Manifest.mpd", fromMS: 0, toMS: 100_000},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			cfg, err := processURLCfg(tc.url, 200_000)
+			require.NoError(t, err)
+			require.Equal(t, tc.wantedTimes, mpdChangeTimesMS(cfg, tc.fromMS, tc.toMS))
+		})
+	}
+}
+
+func TestMPDExpiryEmsg(t *testing.T) {
+	cfg := ServerConfig{
+		VodRoot:   "testdata/assets",
+		TimeoutS:  0,
+		LogFormat: logging.LogDiscard,
+	}
+	err := logging.InitSlog(cfg.LogLevel, cfg.LogFormat)
+	require.NoError(t, err)
+	server, err := SetupServer(context.Background(), &cfg)
+	require.NoError(t, err)
+	ts := httptest.NewServer(server.Router)
+	defer ts.Close()
+
+	// The period starting at 60s is announced in the segments covering [56s, 60s)
+	testCases := []struct {
+		desc         string
+		url          string
+		wantedCode   int
+		wantedEmsg   bool
+		wantedInBody string
+	}{
+		{desc: "MPD", url: "/livesim2/mpdexpiry_4/periods_60/testpic_2s/Manifest.mpd", wantedCode: http.StatusOK,
+			wantedInBody: `<InbandEventStream schemeIdUri="urn:mpeg:dash:event:2012" value="1">`},
+		{desc: "before lead time", url: "/livesim2/mpdexpiry_4/periods_60/testpic_2s/V300/27.m4s", wantedCode: http.StatusOK},
+		{desc: "first video segment", url: "/livesim2/mpdexpiry_4/periods_60/testpic_2s/V300/28.m4s", wantedCode: http.StatusOK,
+			wantedEmsg: true, wantedInBody: "1970-01-01T00:01:00Z"},
+		{desc: "last video segment", url: "/livesim2/mpdexpiry_4/periods_60/testpic_2s/V300/29.m4s", wantedCode: http.StatusOK,
+			wantedEmsg: true},
+		{desc: "audio segment", url: "/livesim2/mpdexpiry_4/periods_60/testpic_2s/A48/29.m4s", wantedCode: http.StatusOK,
+			wantedEmsg: true},
+		{desc: "new period", url: "/livesim2/mpdexpiry_4/periods_60/testpic_2s/V300/30.m4s", wantedCode: http.StatusOK},
+		{desc: "single period", url: "/livesim2/mpdexpiry_4/testpic_2s/V300/29.m4s", wantedCode: http.StatusOK},
+		{desc: "bad lead time", url: "/livesim2/mpdexpiry_0/testpic_2s/Manifest.mpd", wantedCode: http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			resp, body := testFullRequest(t, ts, "GET", tc.url+"?nowMS=100000", nil)
+			require.Equal(t, tc.wantedCode, resp.StatusCode)
+			if tc.wantedCode != http.StatusOK {
+				return
+			}
+			if strings.HasSuffix(tc.url, ".m4s") {
+				require.Equal(t, tc.wantedEmsg, strings.Contains(string(body), mpdEventSchemeIDURI))
+			}
+			if tc.wantedInBody != "" {
+				require.Contains(t, string(body), tc.wantedInBody)
+			}
+		})
+	}
+}
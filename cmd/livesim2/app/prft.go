@@ -0,0 +1,52 @@
+// Copyright 2023, DASH-Industry Forum. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.md file.
+
+package app
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/Eyevinn/mp4ff/mp4"
+)
+
+// ntpEpochOffsetS is the number of seconds from 1900-01-01 (NTP epoch) to 1970-01-01 (Unix epoch).
+const ntpEpochOffsetS = 2_208_988_800
+
+// prftWallClockMS returns the wall-clock time in Unix milliseconds when the sample at mediaTime
+// was input to the encoder. The media timeline starts at availabilityStartTime, and
+// the optional encoder delay moves the input time earlier.
+func prftWallClockMS(cfg *ResponseConfig, mediaTime, timescale uint64) int64 {
+	wallClockMS := int64(cfg.StartTimeS)*1000 + int64(mediaTime*1000/timescale)
+	if cfg.PrftDelayMS != nil {
+		wallClockMS -= int64(*cfg.PrftDelayMS)
+	}
+	return wallClockMS
+}
+
+// ntp64 returns the 64-bit NTP timestamp for unixMS milliseconds since 1970.
+func ntp64(unixMS int64) uint64 {
+	ntpMS := uint64(unixMS + ntpEpochOffsetS*1000)
+	return (ntpMS/1000)<<32 | (ntpMS%1000)<<32/1000
+}
+
+// addPrft inserts a prft box (flags 0, encoder input) before the moof box of frag.
+// The media time is the decode time of the first sample in the fragment.
+func addPrft(cfg *ResponseConfig, frag *mp4.Fragment, timescale uint32) error {
+	traf := frag.Moof.Traf
+	mediaTime := traf.Tfdt.BaseMediaDecodeTime()
+	prft := &mp4.PrftBox{
+		Version:          1,
+		Flags:            0,
+		ReferenceTrackID: traf.Tfhd.TrackID,
+		NTPTimestamp:     mp4.NTP64(ntp64(prftWallClockMS(cfg, mediaTime, uint64(timescale)))),
+		MediaTime:        mediaTime,
+	}
+	moofIdx := slices.IndexFunc(frag.Children, func(b mp4.Box) bool { return b.Type() == "moof" })
+	if moofIdx < 0 {
+		return fmt.Errorf("no moof in fragment")
+	}
+	frag.Children = slices.Insert(frag.Children, moofIdx, mp4.Box(prft))
+	return nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2023, DASH-Industry Forum. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.md file.
+
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Dash-Industry-Forum/livesim2/pkg/logging"
+	"github.com/Eyevinn/mp4ff/mp4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNTP64(t *testing.T) {
+	testCases := []struct {
+		unixMS int64
+		wanted uint64
+	}{
+		{unixMS: 0, wanted: ntpEpochOffsetS << 32},
+		{unixMS: 1500, wanted: (ntpEpochOffsetS+1)<<32 | 1<<31},
+		{unixMS: -500, wanted: (ntpEpochOffsetS-1)<<32 | 1<<31},
+	}
+	for _, tc := range testCases {
+		require.Equal(t, tc.wanted, ntp64(tc.unixMS))
+	}
+}
+
+func TestPrftWallClock(t *testing.T) {
+	cfg := &ResponseConfig{StartTimeS: 100}
+	require.Equal(t, int64(102_000), prftWallClockMS(cfg, 180_000, 90_000))
+	cfg.PrftDelayMS = Ptr(500)
+	require.Equal(t, int64(101_500), prftWallClockMS(cfg, 180_000, 90_000))
+}
+
+func TestPrftBoxes(t *testing.T) {
+	cfg := ServerConfig{
+		VodRoot:   "testdata/assets",
+		TimeoutS:  0,
+		LogFormat: logging.LogDiscard,
+	}
+	err := logging.InitSlog(cfg.LogLevel, cfg.LogFormat)
+	require.NoError(t, err)
+	server, err := SetupServer(context.Background(), &cfg)
+	require.NoError(t, err)
+	ts := httptest.NewServer(server.Router)
+	defer ts.Close()
+
+	const videoTimescale = 90_000
+	testCases := []struct {
+		desc         string
+		url          string
+		wantedCode   int
+		wantedNrPrft int
+		wantedInBody []string
+	}{
+		{desc: "MPD", url: "/livesim2/start_100/prft_500/testpic_2s/Manifest.mpd", wantedCode: http.StatusOK,
+			wantedInBody: []string{`inband="true"`, `wallClockTime="1970-01-01T00:01:39.5Z"`}},
+		{desc: "segment", url: "/livesim2/start_100/prft_500/testpic_2s/V300/45.m4s", wantedCode: http.StatusOK,
+			wantedNrPrft: 1},
+		{desc: "chunked segment", url: "/livesim2/start_100/prft_500/ltgt_2500/ato_1/chunkdur_0.5/testpic_2s/V300/45.m4s",
+			wantedCode: http.StatusOK, wantedNrPrft: 4},
+		{desc: "no prft", url: "/livesim2/start_100/testpic_2s/V300/45.m4s", wantedCode: http.StatusOK},
+		{desc: "negative delay", url: "/livesim2/prft_-1/testpic_2s/Manifest.mpd", wantedCode: http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			resp, body := testFullRequest(t, ts, "GET", tc.url+"?nowMS=200000", nil)
+			require.Equal(t, tc.wantedCode, resp.StatusCode)
+			if tc.wantedCode != http.StatusOK {
+				return
+			}
+			if len(tc.wantedInBody) > 0 {
+				for _, wanted := range tc.wantedInBody {
+					require.Contains(t, string(body), wanted)
+				}
+				return
+			}
+			nrPrft := 0
+			var prevPrft *mp4.PrftBox
+			for pos := 0; pos < len(body); {
+				size := int(binary.BigEndian.Uint32(body[pos:]))
+				boxType := string(body[pos+4 : pos+8])
+				switch boxType {
+				case "prft":
+					box, err := mp4.DecodeBox(0, bytes.NewReader(body[pos:pos+size]))
+					require.NoError(t, err)
+					prevPrft = box.(*mp4.PrftBox)
+					nrPrft++
+				case "moof":
+					if tc.wantedNrPrft > 0 {
+						require.NotNil(t, prevPrft, "prft before moof")
+						moof, err := mp4.DecodeBox(0, bytes.NewReader(body[pos:pos+size]))
+						require.NoError(t, err)
+						mediaTime := moof.(*mp4.MoofBox).Traf.Tfdt.BaseMediaDecodeTime()
+						require.Equal(t, mediaTime, prevPrft.MediaTime)
+						wallClockMS := int64(100_000 + mediaTime*1000/videoTimescale - 500)
+						require.Equal(t, ntp64(wallClockMS), uint64(prevPrft.NTPTimestamp))
+					}
+					prevPrft = nil
+				}
+				pos += size
+			}
+			require.Equal(t, tc.wantedNrPrft, nrPrft)
+		})
+	}
+}
@@ -12,7 +12,10 @@ import (
 
 var (
 	defaultBuckets = []float64{5, 10, 20, 50, 100, 200, 500, 1000}
+	bufferBuckets  = []float64{500, 1000, 2000, 5000, 10000, 20000, 30000, 60000}
+	kbpsBuckets    = []float64{100, 300, 1000, 3000, 10000, 30000, 100000}
 	prometheusMW   prometheusMiddleware
+	cmcdMetrics    cmcdHistograms
 )
 
 const (
@@ -22,6 +25,11 @@ const (
 	mpdLatencyName     = "mpd_request_duration_milliseconds"
 	otherReqsName      = "other_requests_total"
 	otherLatencyName   = "other_request_duration_milliseconds"
+	cmcdSessionsName   = "cmcd_sessions"
+	cmcdBufferName     = "cmcd_buffer_length_milliseconds"
+	cmcdBitrateName    = "cmcd_encoded_bitrate_kbps"
+	cmcdThroughputName = "cmcd_measured_throughput_kbps"
+	cmcdReqMaxTputName = "cmcd_requested_max_throughput_kbps"
 )
 
 // prometheusMiddleware provides a handler that exposes prometheus metrics for various requests
@@ -47,6 +55,20 @@ func init() {
 		"Number other requests processed, partitioned by status code.", "livesim2")
 	prometheusMW.otherLatency = newHistogram(otherLatencyName,
 		"Other response latency.", "livesim2", defaultBuckets)
+	cmcdMetrics.sessions = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        cmcdSessionsName,
+		Help:        "Number of CMCD sessions tracked.",
+		ConstLabels: prometheus.Labels{"service": "livesim2"},
+	})
+	prometheus.MustRegister(cmcdMetrics.sessions)
+	cmcdMetrics.bufferLength = newPlainHistogram(cmcdBufferName,
+		"CMCD buffer length (bl) reported by players.", "livesim2", bufferBuckets)
+	cmcdMetrics.bitrate = newPlainHistogram(cmcdBitrateName,
+		"CMCD encoded bitrate (br) reported by players.", "livesim2", kbpsBuckets)
+	cmcdMetrics.throughput = newPlainHistogram(cmcdThroughputName,
+		"CMCD measured throughput (mtp) reported by players.", "livesim2", kbpsBuckets)
+	cmcdMetrics.reqMaxThroughput = newPlainHistogram(cmcdReqMaxTputName,
+		"CMCD requested maximum throughput (rtp) reported by players.", "livesim2", kbpsBuckets)
 }
 
 // NewPrometheusMiddleware returns a new prometheus Middleware handler.
@@ -82,6 +104,33 @@ func (mw prometheusMiddleware) handler(next http.Handler) http.Handler {
 	return http.HandlerFunc(fn)
 }
 
+// cmcdHistograms provides prometheus metrics aggregated over all CMCD sessions.
+// The values are not labeled by session ID, since that would give unbounded cardinality.
+type cmcdHistograms struct {
+	sessions         prometheus.Gauge
+	bufferLength     prometheus.Histogram
+	bitrate          prometheus.Histogram
+	throughput       prometheus.Histogram
+	reqMaxThroughput prometheus.Histogram
+}
+
+// observe adds the values reported in c to the histograms.
+func (h cmcdHistograms) observe(c CMCD, nrSessions int) {
+	h.sessions.Set(float64(nrSessions))
+	if bl, ok := c.IntVal("bl"); ok {
+		h.bufferLength.Observe(float64(bl))
+	}
+	if br, ok := c.IntVal("br"); ok {
+		h.bitrate.Observe(float64(br))
+	}
+	if mtp, ok := c.IntVal("mtp"); ok {
+		h.throughput.Observe(float64(mtp))
+	}
+	if rtp, ok := c.IntVal("rtp"); ok {
+		h.reqMaxThroughput.Observe(float64(rtp))
+	}
+}
+
 func newCounter(counterName, help, serviceName string) *prometheus.CounterVec {
 	cv := prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -107,3 +156,14 @@ func newHistogram(histogramName, help, serviceName string, buckets []float64) *p
 	prometheus.MustRegister(h)
 	return h
 }
+
+func newPlainHistogram(histogramName, help, serviceName string, buckets []float64) prometheus.Histogram {
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:        histogramName,
+		Help:        help,
+		ConstLabels: prometheus.Labels{"service": serviceName},
+		Buckets:     buckets,
+	})
+	prometheus.MustRegister(h)
+	return h
+}
@@ -56,9 +56,14 @@ func (s *Server) Routes(ctx context.Context) error {
 	s.Router.MethodFunc("GET", "/static/*", s.embeddedStaticHandlerFunc)
 	s.Router.MethodFunc("HEAD", "/static/*", s.embeddedStaticHandlerFunc)
 	s.Router.MethodFunc("GET", "/reqcount", s.reqCountHandlerFunc)
+	s.Router.MethodFunc("GET", "/cmcd", s.cmcdHandlerFunc)
+	s.Router.MethodFunc("GET", "/callback", s.callbackHandlerFunc)
+	s.Router.MethodFunc("GET", "/callbacks", s.callbacksHandlerFunc)
 	s.Router.MethodFunc("OPTIONS", "/*", s.optionsHandlerFunc)
 	s.Router.Handle("/player/*", createReversePlayerProxy("/player", s.Cfg.PlayURL))
 	s.Router.MethodFunc("GET", "/patch/*", s.patchHandlerFunc)
+	s.Router.MethodFunc("GET", "/xlink/*", s.xlinkHandlerFunc)
+	s.Router.MethodFunc("GET", "/steering/*", s.steeringHandlerFunc)
 	s.Router.MethodFunc("GET", "/", s.indexHandlerFunc)
 	s.Router.MethodFunc("POST", "/*", s.laURLHandlerFunc)
 	// LiveRouter is mounted at /livesim2
@@ -0,0 +1,105 @@
+// Copyright 2023, DASH-Industry Forum. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.md file.
+
+package app
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/Dash-Industry-Forum/livesim2/pkg/scte35"
+	m "github.com/Eyevinn/dash-mpd/mpd"
+	"github.com/Eyevinn/mp4ff/mp4"
+)
+
+const (
+	scte35XMLBinSchemeIDURI = "urn:scte:scte35:2014:xml+bin"
+	scte35SignalNamespace   = "http://www.scte.org/schemas/35/2016"
+)
+
+// addSCTE35EventStreams inserts SCTE-35 EventStreams in all Periods of mpdXML.
+// The events are the cues with splice time in the time-shift buffer or announced at nowMS.
+// They have the same ids and binary payloads as the in-band emsg boxes, and every
+// event is put in the Period where its splice time is.
+// The EventStream is inserted before the first AdaptationSet, since the
+// dash-mpd Event element cannot hold the Signal child element.
+func addSCTE35EventStreams(mpdXML []byte, mpd *m.MPD, a *asset, cfg *ResponseConfig, nowMS int) ([]byte, error) {
+	timescale := videoTimescale(a)
+	if timescale == 0 {
+		return mpdXML, nil // No video segments that carry the cues either
+	}
+	windowStartMS, windowEndMS := mpdEventWindowMS(cfg, nowMS, cfg.SCTE35.AnnounceS*1000)
+	start := uint64(windowStartMS) * timescale / 1000
+	end := uint64(windowEndMS) * timescale / 1000
+	startType := scte35.SegmentationStartTypes[cfg.SCTE35Cmd]
+	emsgs, err := cfg.SCTE35.CueEmsgs(start, end, timescale, startType)
+	if err != nil {
+		return nil, fmt.Errorf("scte35 cues: %w", err)
+	}
+	if len(emsgs) == 0 {
+		return mpdXML, nil
+	}
+
+	out := make([]byte, 0, len(mpdXML)+len(emsgs)*256)
+	pos, searchPos := 0, 0
+	for i, p := range mpd.Periods {
+		idx := indexPeriodStart(mpdXML, searchPos)
+		if idx < 0 {
+			return nil, fmt.Errorf("period %d not found in MPD", i)
+		}
+		searchPos = idx + len("<Period")
+		if p.XlinkHref != "" {
+			continue // Remote period without content
+		}
+		asIdx := bytes.Index(mpdXML[idx:], []byte("<AdaptationSet"))
+		if asIdx < 0 {
+			return nil, fmt.Errorf("no AdaptationSet in period %d", i)
+		}
+		asIdx += idx
+		periodStartMS, periodEndMS := periodItvlMS(mpd.Periods, i)
+		periodStart := periodStartMS * timescale / 1000
+		var periodEmsgs []*mp4.EmsgBox
+		for _, e := range emsgs {
+			if periodStart <= e.PresentationTime && e.PresentationTime*1000/timescale < periodEndMS {
+				periodEmsgs = append(periodEmsgs, e)
+			}
+		}
+		out = append(out, mpdXML[pos:asIdx]...)
+		pos, searchPos = asIdx, asIdx
+		if len(periodEmsgs) > 0 {
+			lineStart := bytes.LastIndexByte(mpdXML[:asIdx], '\n') + 1
+			indent := string(mpdXML[lineStart:asIdx])
+			out = append(out, scte35EventStreamXML(periodEmsgs, timescale, periodStart, indent)...)
+		}
+	}
+	out = append(out, mpdXML[pos:]...)
+	return out, nil
+}
+
+// scte35EventStreamXML returns an EventStream element with the emsgs as binary Signal events.
+// The returned string ends with indent, so that it can be inserted before an indented element.
+func scte35EventStreamXML(emsgs []*mp4.EmsgBox, timescale, pto uint64, indent string) string {
+	const unit = "  "
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "<EventStream schemeIdUri=%q timescale=\"%d\" presentationTimeOffset=\"%d\">\n",
+		scte35XMLBinSchemeIDURI, timescale, pto)
+	for _, e := range emsgs {
+		sb.WriteString(indent + unit)
+		fmt.Fprintf(&sb, "<Event presentationTime=\"%d\"", e.PresentationTime)
+		if e.EventDuration > 0 {
+			fmt.Fprintf(&sb, " duration=\"%d\"", e.EventDuration)
+		}
+		fmt.Fprintf(&sb, " id=\"%d\">\n", e.ID)
+		fmt.Fprintf(&sb, "%s<Signal xmlns=%q>\n", indent+unit+unit, scte35SignalNamespace)
+		fmt.Fprintf(&sb, "%s<Binary>%s</Binary>\n", indent+unit+unit+unit,
+			base64.StdEncoding.EncodeToString(e.MessageData))
+		sb.WriteString(indent + unit + unit + "</Signal>\n")
+		sb.WriteString(indent + unit + "</Event>\n")
+	}
+	sb.WriteString(indent + "</EventStream>\n")
+	sb.WriteString(indent)
+	return sb.String()
+}
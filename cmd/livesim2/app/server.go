@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/Dash-Industry-Forum/livesim2/pkg/scte35"
 	"github.com/go-chi/chi/v5"
 
 	htmpl "html/template"
@@ -29,6 +30,13 @@ type Server struct {
 	textTemplates *ttmpl.Template
 	htmlTemplates *htmpl.Template
 	reqLimiter    *IPRequestLimiter
+	cmcd          *cmcdStore
+	callbacks     *callbackStore
+	// scte35Schedules are the parsed SCTE35Schedules from the server configuration
+	scte35Schedules map[string]scte35.Schedule
+	// throttleTraces are the bandwidth traces read from the ThrottleTraces files
+	throttleTraces map[string]*throttleTrace
+	throttles      *throttleStore
 }
 
 func (s *Server) healthzHandlerFunc(w http.ResponseWriter, r *http.Request) {
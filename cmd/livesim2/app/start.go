@@ -57,6 +57,10 @@ func SetupServer(ctx context.Context, cfg *ServerConfig) (*Server, error) {
 		l.Use(ltrMw)
 		v.Use(ltrMw)
 	}
+	cmcd := newCMCDStore(cmcdMaxNrSessions)
+	cmcdMw := newCMCDMiddleware(cmcd)
+	l.Use(cmcdMw)
+	v.Use(cmcdMw)
 
 	// Mount livesim and vod routers
 	r.Mount("/livesim2", l)
@@ -70,6 +74,19 @@ func SetupServer(ctx context.Context, cfg *ServerConfig) (*Server, error) {
 		Cfg:        cfg,
 		assetMgr:   newAssetMgr(vodFS, cfg.RepDataRoot, cfg.WriteRepData),
 		reqLimiter: reqLimiter,
+		cmcd:       cmcd,
+		callbacks:  newCallbackStore(callbackMaxNrHits),
+		throttles:  newThrottleStore(throttleMaxNrSessions),
+	}
+
+	server.scte35Schedules, err = parseSCTE35Schedules(cfg.SCTE35Schedules)
+	if err != nil {
+		return nil, err
+	}
+
+	server.throttleTraces, err = loadThrottleTraces(cfg.ThrottleTraces)
+	if err != nil {
+		return nil, err
 	}
 
 	r.Route("/api", createRouteAPI(&server))
@@ -9,6 +9,8 @@ import (
 	"math"
 	"strconv"
 	"strings"
+
+	"github.com/Dash-Industry-Forum/livesim2/pkg/scte35"
 )
 
 type strConvAccErr struct {
@@ -182,3 +184,64 @@ func (s *strConvAccErr) ParseLossItvls(key, val string) []LossItvls {
 	}
 	return itvls
 }
+
+// ParseSCTE35Schedule parses a number of ads per minute (1, 2, or 3) or an inline schedule.
+// Other values are returned as a schedule name to be resolved from the server configuration.
+func (s *strConvAccErr) ParseSCTE35Schedule(key, val string) (*scte35.Schedule, string) {
+	if s.err != nil {
+		return nil, ""
+	}
+	var sched scte35.Schedule
+	var err error
+	perMinute, errAtoi := strconv.Atoi(val)
+	switch {
+	case errAtoi == nil:
+		sched, err = scte35.PerMinuteSchedule(perMinute)
+	case strings.Contains(val, ":"):
+		sched, err = scte35.ParseSchedule(val)
+	default:
+		return nil, val
+	}
+	if err != nil {
+		s.err = fmt.Errorf("key=%s, err=%w", key, err)
+		return nil, ""
+	}
+	return &sched, ""
+}
+
+// ParseThrottle parses a constant rate in kbps.
+// Other values are returned as a trace name to be resolved from the server configuration.
+func (s *strConvAccErr) ParseThrottle(key, val string) (*int, string) {
+	if s.err != nil {
+		return nil, ""
+	}
+	kbps, err := strconv.Atoi(val)
+	if err != nil {
+		return nil, val
+	}
+	return &kbps, ""
+}
+
+func (s *strConvAccErr) ParseSteeringScript(key, val string) []SteeringItvl {
+	if s.err != nil {
+		return nil
+	}
+	itvls, err := CreateSteeringScript(val)
+	if err != nil {
+		s.err = fmt.Errorf("key=%s, err=%w", key, err)
+		return nil
+	}
+	return itvls
+}
+
+func (s *strConvAccErr) ParseCMSDScript(key, val string) []CMSDItvl {
+	if s.err != nil {
+		return nil
+	}
+	itvls, err := CreateCMSDScript(val)
+	if err != nil {
+		s.err = fmt.Errorf("key=%s, err=%w", key, err)
+		return nil
+	}
+	return itvls
+}
@@ -78,5 +78,5 @@ func TestHTMLTemplates(t *testing.T) {
 	require.NoError(t, err)
 	welcomeStr := buf.String()
 	require.Greater(t, strings.Index(welcomeStr, `href="http://localhost:8888/assets"`), 0)
-	require.Equal(t, 6, len(textTemplates.Templates()))
+	require.Equal(t, 7, len(textTemplates.Templates()))
 }
@@ -0,0 +1,268 @@
+// Copyright 2023, DASH-Industry Forum. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.md file.
+
+package app
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// throttleMaxNrSessions is the max number of throttled sessions kept. The least recently seen is dropped.
+	throttleMaxNrSessions = 1000
+	// throttleChunkSize is the number of bytes written at a time, so that concurrent responses share the rate.
+	throttleChunkSize = 4096
+)
+
+// throttleTrace is a bandwidth trace that is repeated cyclically.
+// Sample i has rate kbps[i] from times[i] until times[i+1], or until period for the last sample.
+type throttleTrace struct {
+	times  []time.Duration
+	kbps   []float64
+	period time.Duration
+}
+
+// constantThrottleTrace returns a trace with a constant rate.
+func constantThrottleTrace(kbps int) *throttleTrace {
+	return &throttleTrace{
+		times:  []time.Duration{0},
+		kbps:   []float64{float64(kbps)},
+		period: time.Second,
+	}
+}
+
+// parseThrottleTrace parses a bandwidth trace with one "<time_s> <kbps>" sample per line.
+// The fields may also be separated by a comma. Empty lines and lines starting with # are skipped.
+// The times must be increasing and are taken relative to the first sample.
+// The last sample lasts as long as the one before it, or 1s if there is only one sample.
+func parseThrottleTrace(r io.Reader) (*throttleTrace, error) {
+	var tr throttleTrace
+	var firstS float64
+	scanner := bufio.NewScanner(r)
+	lineNr := 0
+	for scanner.Scan() {
+		lineNr++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.FieldsFunc(line, func(c rune) bool {
+			return c == ',' || c == ' ' || c == '\t'
+		})
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: want 2 fields, got %d", lineNr, len(fields))
+		}
+		timeS, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: time: %w", lineNr, err)
+		}
+		kbps, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: rate: %w", lineNr, err)
+		}
+		if kbps < 0 {
+			return nil, fmt.Errorf("line %d: negative rate", lineNr)
+		}
+		if len(tr.times) == 0 {
+			firstS = timeS
+		}
+		t := time.Duration((timeS - firstS) * float64(time.Second))
+		if len(tr.times) > 0 && t <= tr.times[len(tr.times)-1] {
+			return nil, fmt.Errorf("line %d: time not increasing", lineNr)
+		}
+		tr.times = append(tr.times, t)
+		tr.kbps = append(tr.kbps, kbps)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	switch n := len(tr.times); n {
+	case 0:
+		return nil, fmt.Errorf("no samples")
+	case 1:
+		tr.period = time.Second
+	default:
+		tr.period = 2*tr.times[n-1] - tr.times[n-2]
+	}
+	maxKbps := 0.0
+	for _, kbps := range tr.kbps {
+		maxKbps = max(maxKbps, kbps)
+	}
+	if maxKbps == 0 {
+		return nil, fmt.Errorf("all rates are zero")
+	}
+	return &tr, nil
+}
+
+// rateAt returns the rate at elapsed time since the trace start, and the elapsed time when it changes.
+func (tr *throttleTrace) rateAt(elapsed time.Duration) (kbps float64, end time.Duration) {
+	cycleStart := elapsed - elapsed%tr.period
+	pos := elapsed - cycleStart
+	idx := sort.Search(len(tr.times), func(i int) bool { return tr.times[i] > pos }) - 1
+	end = cycleStart + tr.period
+	if idx+1 < len(tr.times) {
+		end = cycleStart + tr.times[idx+1]
+	}
+	return tr.kbps[idx], end
+}
+
+// loadThrottleTraces reads the named bandwidth trace files of the server configuration.
+// Names must not be numbers, since these are constant rates in the URL.
+func loadThrottleTraces(paths map[string]string) (map[string]*throttleTrace, error) {
+	traces := make(map[string]*throttleTrace, len(paths))
+	for name, path := range paths {
+		if _, err := strconv.Atoi(name); err == nil || name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("bad throttle trace name %q", name)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("throttle trace %q: %w", name, err)
+		}
+		tr, err := parseThrottleTrace(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("throttle trace %q: %w", name, err)
+		}
+		traces[name] = tr
+	}
+	return traces, nil
+}
+
+// throttleSession is the shared link of all throttled requests of a session.
+// The trace starts when the session is created.
+type throttleSession struct {
+	mu        sync.Mutex
+	trace     *throttleTrace
+	start     time.Time
+	busyUntil time.Time
+	lastSeen  time.Time
+}
+
+// reserve reserves the link for sending nrBytes from now, or when earlier reservations are done,
+// and returns the time when the bytes have been sent.
+func (ts *throttleSession) reserve(now time.Time, nrBytes int) time.Time {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.lastSeen = now
+	t := now
+	if ts.busyUntil.After(t) {
+		t = ts.busyUntil
+	}
+	bits := float64(nrBytes * 8)
+	for bits > 0 {
+		elapsed := t.Sub(ts.start)
+		kbps, end := ts.trace.rateAt(elapsed)
+		bitsPerS := kbps * 1000
+		left := end - elapsed
+		if bitsPerS*left.Seconds() >= bits {
+			t = t.Add(time.Duration(bits / bitsPerS * float64(time.Second)))
+			break
+		}
+		bits -= bitsPerS * left.Seconds()
+		t = t.Add(left)
+	}
+	ts.busyUntil = t
+	return t
+}
+
+// throttleStore keeps the throttled sessions.
+type throttleStore struct {
+	mu            sync.Mutex
+	maxNrSessions int
+	sessions      map[string]*throttleSession
+}
+
+func newThrottleStore(maxNrSessions int) *throttleStore {
+	return &throttleStore{
+		maxNrSessions: maxNrSessions,
+		sessions:      make(map[string]*throttleSession),
+	}
+}
+
+// session returns the session for key, and creates it with trace starting at now if needed.
+func (tst *throttleStore) session(key string, trace *throttleTrace, now time.Time) *throttleSession {
+	tst.mu.Lock()
+	defer tst.mu.Unlock()
+	if s, ok := tst.sessions[key]; ok {
+		return s
+	}
+	if len(tst.sessions) >= tst.maxNrSessions {
+		tst.dropOldest()
+	}
+	s := &throttleSession{trace: trace, start: now, lastSeen: now}
+	tst.sessions[key] = s
+	return s
+}
+
+// dropOldest removes the least recently seen session. Must be called with lock held.
+func (tst *throttleStore) dropOldest() {
+	var oldestKey string
+	var oldest time.Time
+	for key, s := range tst.sessions {
+		s.mu.Lock()
+		lastSeen := s.lastSeen
+		s.mu.Unlock()
+		if oldestKey == "" || lastSeen.Before(oldest) {
+			oldestKey, oldest = key, lastSeen
+		}
+	}
+	delete(tst.sessions, oldestKey)
+}
+
+// throttleSessionKey returns the key of the session that a request belongs to.
+// A session is all requests from one client with the same URL configuration.
+func throttleSessionKey(r *http.Request, cfg *ResponseConfig) string {
+	client, err := ipFromRequest(r)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+	return client + " " + strings.Join(cfg.URLParts[:cfg.URLContentIdx], "/")
+}
+
+// throttledWriter writes at the rate of a throttle session.
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	session *throttleSession
+}
+
+// Write writes b in chunks, each when the session link has had time to send it.
+func (tw *throttledWriter) Write(b []byte) (int, error) {
+	nrWritten := 0
+	for nrWritten < len(b) {
+		size := min(len(b)-nrWritten, throttleChunkSize)
+		sentAt := tw.session.reserve(time.Now(), size)
+		timer := time.NewTimer(time.Until(sentAt))
+		select {
+		case <-tw.ctx.Done():
+			timer.Stop()
+			return nrWritten, tw.ctx.Err()
+		case <-timer.C:
+		}
+		n, err := tw.ResponseWriter.Write(b[nrWritten : nrWritten+size])
+		nrWritten += n
+		if err != nil {
+			return nrWritten, err
+		}
+		tw.Flush()
+	}
+	return nrWritten, nil
+}
+
+// Flush sends any buffered data to the client.
+func (tw *throttledWriter) Flush() {
+	if f, ok := tw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
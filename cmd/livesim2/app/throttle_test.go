@@ -0,0 +1,151 @@
+// Copyright 2023, DASH-Industry Forum. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.md file.
+
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dash-Industry-Forum/livesim2/pkg/logging"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseThrottleTrace(t *testing.T) {
+	testCases := []struct {
+		desc         string
+		trace        string
+		wantedTimes  []time.Duration
+		wantedKbps   []float64
+		wantedPeriod time.Duration
+		wantedErr    string
+	}{
+		{desc: "spaces", trace: "10 1000\n12 500\n13 2000\n",
+			wantedTimes: []time.Duration{0, 2 * time.Second, 3 * time.Second}, wantedKbps: []float64{1000, 500, 2000},
+			wantedPeriod: 4 * time.Second},
+		{desc: "commas and comments", trace: "# time,kbps\n\n0.0,1500.5\n0.5,0\n",
+			wantedTimes: []time.Duration{0, 500 * time.Millisecond}, wantedKbps: []float64{1500.5, 0},
+			wantedPeriod: time.Second},
+		{desc: "single sample", trace: "5 800",
+			wantedTimes: []time.Duration{0}, wantedKbps: []float64{800}, wantedPeriod: time.Second},
+		{desc: "empty", trace: "# nothing\n", wantedErr: "no samples"},
+		{desc: "bad fields", trace: "0 100 3\n", wantedErr: "line 1: want 2 fields, got 3"},
+		{desc: "decreasing time", trace: "0 100\n2 100\n1 100\n", wantedErr: "line 3: time not increasing"},
+		{desc: "negative rate", trace: "0 -100\n", wantedErr: "line 1: negative rate"},
+		{desc: "zero rates", trace: "0 0\n1 0\n", wantedErr: "all rates are zero"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			tr, err := parseThrottleTrace(strings.NewReader(tc.trace))
+			if tc.wantedErr != "" {
+				require.EqualError(t, err, tc.wantedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.wantedTimes, tr.times)
+			require.Equal(t, tc.wantedKbps, tr.kbps)
+			require.Equal(t, tc.wantedPeriod, tr.period)
+		})
+	}
+}
+
+func TestThrottleTraceRate(t *testing.T) {
+	tr, err := parseThrottleTrace(strings.NewReader("0 1000\n2 500\n3 2000\n"))
+	require.NoError(t, err)
+	testCases := []struct {
+		elapsed    time.Duration
+		wantedKbps float64
+		wantedEnd  time.Duration
+	}{
+		{elapsed: 0, wantedKbps: 1000, wantedEnd: 2 * time.Second},
+		{elapsed: 2 * time.Second, wantedKbps: 500, wantedEnd: 3 * time.Second},
+		{elapsed: 3500 * time.Millisecond, wantedKbps: 2000, wantedEnd: 4 * time.Second},
+		{elapsed: 9 * time.Second, wantedKbps: 1000, wantedEnd: 10 * time.Second},
+	}
+	for _, tc := range testCases {
+		kbps, end := tr.rateAt(tc.elapsed)
+		require.Equal(t, tc.wantedKbps, kbps, tc.elapsed)
+		require.Equal(t, tc.wantedEnd, end, tc.elapsed)
+	}
+}
+
+func TestThrottleReserve(t *testing.T) {
+	tr, err := parseThrottleTrace(strings.NewReader("0 800\n1 0\n2 1600\n"))
+	require.NoError(t, err)
+	start := time.Unix(1000, 0)
+	store := newThrottleStore(2)
+	s := store.session("a", tr, start)
+	// 800 kbps is 100 bytes per ms
+	require.Equal(t, start.Add(500*time.Millisecond), s.reserve(start, 50_000))
+	// The link is shared, so the next reservation starts when the first is done.
+	// The rest of the 800 kbps sample sends 50_000 bytes, then there is an outage,
+	// and the remaining 10_000 bytes are sent at 200 bytes per ms.
+	require.Equal(t, start.Add(2050*time.Millisecond), s.reserve(start.Add(100*time.Millisecond), 60_000))
+	// A later reservation starts at now, where the trace has wrapped around to 800 kbps
+	require.Equal(t, start.Add(3200*time.Millisecond), s.reserve(start.Add(3000*time.Millisecond), 20_000))
+
+	require.Same(t, s, store.session("a", tr, start.Add(time.Hour)))
+	store.session("b", tr, start)
+	store.session("c", tr, start)
+	require.Len(t, store.sessions, 2)
+	require.NotContains(t, store.sessions, "b", "least recently seen session dropped")
+}
+
+func TestThrottledResponses(t *testing.T) {
+	tracePath := filepath.Join(t.TempDir(), "slow.txt")
+	err := os.WriteFile(tracePath, []byte("0 400\n1 800\n"), 0o644)
+	require.NoError(t, err)
+	cfg := ServerConfig{
+		VodRoot:        "testdata/assets",
+		TimeoutS:       0,
+		LogFormat:      logging.LogDiscard,
+		ThrottleTraces: map[string]string{"slow": tracePath},
+	}
+	err = logging.InitSlog(cfg.LogLevel, cfg.LogFormat)
+	require.NoError(t, err)
+	server, err := SetupServer(context.Background(), &cfg)
+	require.NoError(t, err)
+	ts := httptest.NewServer(server.Router)
+	defer ts.Close()
+
+	testCases := []struct {
+		desc       string
+		url        string
+		wantedCode int
+		minKbps    int // Lowest rate in the trace
+		maxKbps    int // Highest rate in the trace
+	}{
+		{desc: "constant rate", url: "/livesim2/throttle_1600/testpic_2s/V300/45.m4s", wantedCode: http.StatusOK,
+			minKbps: 1600, maxKbps: 1600},
+		{desc: "trace", url: "/livesim2/throttle_slow/testpic_2s/V300/45.m4s", wantedCode: http.StatusOK,
+			minKbps: 400, maxKbps: 800},
+		{desc: "unknown trace", url: "/livesim2/throttle_fast/testpic_2s/V300/45.m4s", wantedCode: http.StatusBadRequest},
+		{desc: "zero rate", url: "/livesim2/throttle_0/testpic_2s/V300/45.m4s", wantedCode: http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			start := time.Now()
+			resp, body := testFullRequest(t, ts, "GET", tc.url+"?nowMS=100000", nil)
+			elapsed := time.Since(start)
+			require.Equal(t, tc.wantedCode, resp.StatusCode)
+			if tc.wantedCode != http.StatusOK {
+				return
+			}
+			nrBits := len(body) * 8
+			require.GreaterOrEqual(t, elapsed, time.Duration(nrBits/tc.maxKbps)*time.Millisecond)
+			require.Less(t, elapsed, time.Duration(nrBits/tc.minKbps+1000)*time.Millisecond)
+		})
+	}
+
+	_, err = loadThrottleTraces(map[string]string{"800": tracePath})
+	require.Error(t, err)
+	_, err = loadThrottleTraces(map[string]string{"missing": filepath.Join(t.TempDir(), "missing.txt")})
+	require.Error(t, err)
+}
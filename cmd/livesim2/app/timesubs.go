@@ -176,6 +176,9 @@ func writeTimeSubsMediaSegment(w http.ResponseWriter, cfg *ResponseConfig, a *as
 	if err != nil {
 		return true, fmt.Errorf("bad seg nr %s: %w", nrStr, errNotFound)
 	}
+	if !cfg.inModuloSession(nowMS) {
+		return true, errNotFound
+	}
 	// Must validate that nrOrTime is within valid range
 	// This is done by looking up a corresponding video segment.
 	// That segments also gives the right time range
@@ -205,6 +208,18 @@ func writeTimeSubsMediaSegment(w http.ResponseWriter, cfg *ResponseConfig, a *as
 	if err != nil {
 		return true, fmt.Errorf("createSubtitleStppMediaSegment: %w", err)
 	}
+	if cfg.Tfdt32Flag {
+		err = forceTfdtVersion0(mediaSeg.Fragments)
+		if err != nil {
+			return true, err
+		}
+	}
+	if cfg.SidxFlag {
+		err = addSidx(mediaSeg, nil, SUBS_TIME_TIMESCALE)
+		if err != nil {
+			return true, fmt.Errorf("addSidx: %w", err)
+		}
+	}
 	w.Header().Set("Content-Type", "application/mp4")
 	w.Header().Set("Content-Length", strconv.Itoa(int(mediaSeg.Size())))
 	err = mediaSeg.Encode(w)
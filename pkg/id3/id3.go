@@ -0,0 +1,83 @@
+// Package id3 creates ID3v2.4 tags with timed metadata to be carried in emsg boxes
+// according to the AOM specification "Carriage of ID3 Timed Metadata in CMAF".
+package id3
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	SchemeIDURI = "https://aomedia.org/emsg/ID3"
+	// maxSyncSafe is the limit for sizes coded as 4 syncsafe bytes.
+	maxSyncSafe   = 1 << 28
+	headerSize    = 10
+	encodingUTF8  = 0x03
+	majorVersion  = 0x04
+	revisionNr    = 0x00
+	frameIDLength = 4
+)
+
+// TXXXFrame returns a user-defined text information frame with UTF-8 description and value.
+func TXXXFrame(description, value string) ([]byte, error) {
+	data := make([]byte, 0, 1+len(description)+1+len(value))
+	data = append(data, encodingUTF8)
+	data = append(data, description...)
+	data = append(data, 0)
+	data = append(data, value...)
+	return frame("TXXX", data)
+}
+
+// PRIVFrame returns a private frame with an owner identifier and binary data.
+func PRIVFrame(owner string, privData []byte) ([]byte, error) {
+	data := make([]byte, 0, len(owner)+1+len(privData))
+	data = append(data, owner...)
+	data = append(data, 0)
+	data = append(data, privData...)
+	return frame("PRIV", data)
+}
+
+// frame returns a frame with header and data.
+func frame(id string, data []byte) ([]byte, error) {
+	if len(id) != frameIDLength {
+		return nil, fmt.Errorf("bad frame id %q", id)
+	}
+	size, err := syncSafe(len(data))
+	if err != nil {
+		return nil, fmt.Errorf("frame %s: %w", id, err)
+	}
+	f := make([]byte, 0, headerSize+len(data))
+	f = append(f, id...)
+	f = append(f, size...)
+	f = append(f, 0, 0) // flags
+	f = append(f, data...)
+	return f, nil
+}
+
+// Tag returns an ID3v2.4 tag with the frames.
+func Tag(frames ...[]byte) ([]byte, error) {
+	tagSize := 0
+	for _, f := range frames {
+		tagSize += len(f)
+	}
+	size, err := syncSafe(tagSize)
+	if err != nil {
+		return nil, fmt.Errorf("tag: %w", err)
+	}
+	tag := make([]byte, 0, headerSize+tagSize)
+	tag = append(tag, "ID3"...)
+	tag = append(tag, majorVersion, revisionNr, 0) // No flags
+	tag = append(tag, size...)
+	for _, f := range frames {
+		tag = append(tag, f...)
+	}
+	return tag, nil
+}
+
+// syncSafe returns size as 4 bytes with 7 bits each.
+func syncSafe(size int) ([]byte, error) {
+	if size < 0 || size >= maxSyncSafe {
+		return nil, errors.New("size does not fit in syncsafe integer")
+	}
+	return []byte{byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}, nil
+}
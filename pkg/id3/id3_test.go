@@ -0,0 +1,44 @@
+package id3
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncSafe(t *testing.T) {
+	testCases := []struct {
+		size      int
+		wantedHex string
+		wantedErr bool
+	}{
+		{size: 0, wantedHex: "00000000"},
+		{size: 127, wantedHex: "0000007f"},
+		{size: 128, wantedHex: "00000100"},
+		{size: 257, wantedHex: "00000201"},
+		{size: 1<<28 - 1, wantedHex: "7f7f7f7f"},
+		{size: 1 << 28, wantedErr: true},
+	}
+	for _, tc := range testCases {
+		b, err := syncSafe(tc.size)
+		if tc.wantedErr {
+			require.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, tc.wantedHex, hex.EncodeToString(b))
+	}
+}
+
+func TestTag(t *testing.T) {
+	txxx, err := TXXXFrame("ab", "c")
+	require.NoError(t, err)
+	require.Equal(t, "5458585800000005000003616200"+"63", hex.EncodeToString(txxx))
+	priv, err := PRIVFrame("o", []byte{0xff})
+	require.NoError(t, err)
+	require.Equal(t, "505249560000000300006f00ff", hex.EncodeToString(priv))
+	tag, err := Tag(txxx, priv)
+	require.NoError(t, err)
+	require.Equal(t, "4944330400000000001c"+hex.EncodeToString(txxx)+hex.EncodeToString(priv), hex.EncodeToString(tag))
+}
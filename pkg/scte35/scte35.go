@@ -3,6 +3,9 @@ package scte35
 
 import (
 	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/Comcast/gots/v2"
 	"github.com/Comcast/gots/v2/scte35"
@@ -23,47 +26,229 @@ func IsValidSCTE35Interval(adsPerMinute int) error {
 	}
 }
 
-// CreateEmsgAhead generates an emsg SCTE-35 box if the the segment covers the time 7s before the ad start.
-// Depending on perMinute parameter, the splice inserts are as follows::
+const (
+	// DefaultAnnounceS is the default time between the first emsg announcement and the splice.
+	DefaultAnnounceS = 7
+)
+
+// Break is an ad break given by its offset and duration in a schedule period.
+type Break struct {
+	OffsetS   int `json:"offsetS"`
+	DurationS int `json:"durationS"`
+}
+
+// Schedule is a repeating pattern of ad breaks.
+// Every splice is announced AnnounceS seconds ahead, and the announcement is
+// repeated Repeats times at evenly spaced times before the splice.
+type Schedule struct {
+	PeriodS   int     `json:"periodS"`
+	Breaks    []Break `json:"breaks"`
+	AnnounceS int     `json:"announceS"`
+	Repeats   int     `json:"repeats"`
+}
+
+// PerMinuteSchedule returns the schedule for 1, 2, or 3 ads per minute:
 // 1: 10s after full minute (20s duration)
 // 2: 10s and 40s after full minute (10 duration)
 // 3: 10s, 36s, 46s after full minute (10s duration)
-func CreateEmsgAhead(segStart, segEnd, timescale uint64, perMinute int) (*mp4.EmsgBox, error) {
+func PerMinuteSchedule(perMinute int) (Schedule, error) {
 	if err := IsValidSCTE35Interval(perMinute); err != nil {
-		return nil, err
+		return Schedule{}, err
 	}
-	modMinute := segStart % (60 * timescale)
-	minuteStart := segStart - modMinute
-	var spliceInsertTimes []uint64
-	adDuration := 10 * timescale
+	s := Schedule{PeriodS: 60, AnnounceS: DefaultAnnounceS, Repeats: 1}
 	switch perMinute {
 	case 1:
-		adDuration = 20 * timescale
-		spliceInsertTimes = []uint64{minuteStart + 10*timescale}
+		s.Breaks = []Break{{OffsetS: 10, DurationS: 20}}
 	case 2:
-		spliceInsertTimes = []uint64{minuteStart + 10*timescale, minuteStart + 40*timescale}
+		s.Breaks = []Break{{OffsetS: 10, DurationS: 10}, {OffsetS: 40, DurationS: 10}}
 	case 3:
-		spliceInsertTimes = []uint64{minuteStart + 10*timescale, minuteStart + 36*timescale, minuteStart + 46*timescale}
-	}
-	// We do not need to look into next minute, since first start is 10s after full minute.
-	inInterval := false
-	var spliceTime uint64
-	for _, sit := range spliceInsertTimes {
-		announceTime := sit - 7*timescale
-		if segStart < announceTime && announceTime <= segEnd {
-			inInterval = true
-			spliceTime = sit
-			break
+		s.Breaks = []Break{{OffsetS: 10, DurationS: 10}, {OffsetS: 36, DurationS: 10}, {OffsetS: 46, DurationS: 10}}
+	}
+	return s, nil
+}
+
+// ParseSchedule parses a schedule like "period:900,offset:60,dur:120,announce:8,repeats:3"
+// with one ad break of duration dur starting offset seconds into each period.
+// period and dur are mandatory. offset defaults to 0, announce to DefaultAnnounceS, and repeats to 1.
+func ParseSchedule(pattern string) (Schedule, error) {
+	s := Schedule{AnnounceS: DefaultAnnounceS, Repeats: 1}
+	var b Break
+	for _, part := range strings.Split(pattern, ",") {
+		key, valStr, ok := strings.Cut(part, ":")
+		if !ok {
+			return Schedule{}, fmt.Errorf("bad scte35 schedule part %q", part)
+		}
+		val, err := strconv.Atoi(valStr)
+		if err != nil {
+			return Schedule{}, fmt.Errorf("bad scte35 schedule value %q: %w", part, err)
+		}
+		switch key {
+		case "period":
+			s.PeriodS = val
+		case "offset":
+			b.OffsetS = val
+		case "dur":
+			b.DurationS = val
+		case "announce":
+			s.AnnounceS = val
+		case "repeats":
+			s.Repeats = val
+		default:
+			return Schedule{}, fmt.Errorf("unknown scte35 schedule key %q", key)
 		}
 	}
-	if !inInterval {
-		return nil, nil
+	s.Breaks = []Break{b}
+	if err := s.Validate(); err != nil {
+		return Schedule{}, err
+	}
+	return s, nil
+}
+
+// Validate checks that the breaks are ordered, non-overlapping, and fit in the period.
+func (s Schedule) Validate() error {
+	if s.PeriodS <= 0 {
+		return errors.New("scte35 schedule period must be positive")
+	}
+	if len(s.Breaks) == 0 {
+		return errors.New("scte35 schedule has no breaks")
+	}
+	if s.AnnounceS <= 0 {
+		return errors.New("scte35 announce time must be positive")
+	}
+	if s.Repeats < 1 || s.Repeats > s.AnnounceS {
+		return fmt.Errorf("scte35 repeats must be between 1 and announce time %ds", s.AnnounceS)
+	}
+	prevEndS := 0
+	for _, b := range s.Breaks {
+		if b.DurationS <= 0 {
+			return errors.New("scte35 break duration must be positive")
+		}
+		if b.OffsetS < prevEndS {
+			return errors.New("scte35 breaks must be ordered and not overlap")
+		}
+		prevEndS = b.OffsetS + b.DurationS
+	}
+	if prevEndS > s.PeriodS {
+		return fmt.Errorf("scte35 breaks end at %ds after period %ds", prevEndS, s.PeriodS)
+	}
+	return nil
+}
+
+// AdBreak is an ad break with start time and duration in timescale units.
+type AdBreak struct {
+	Start    uint64
+	Duration uint64
+}
+
+// End returns the end time of the ad break.
+func (b AdBreak) End() uint64 {
+	return b.Start + b.Duration
+}
+
+// AdBreaks returns the ad breaks starting in the interval [start, end).
+// The breaks are the same as the ones announced by CreateEmsgs.
+func (s Schedule) AdBreaks(start, end, timescale uint64) []AdBreak {
+	var breaks []AdBreak
+	periodDur := uint64(s.PeriodS) * timescale
+	for periodStart := start - start%periodDur; periodStart < end; periodStart += periodDur {
+		for _, b := range s.Breaks {
+			t := periodStart + uint64(b.OffsetS)*timescale
+			if start <= t && t < end {
+				breaks = append(breaks, AdBreak{Start: t, Duration: uint64(b.DurationS) * timescale})
+			}
+		}
+	}
+	return breaks
+}
+
+// announceTimes returns the times when the splice at spliceTime is announced.
+// Times before 0 are dropped.
+func (s Schedule) announceTimes(spliceTime, timescale uint64) []uint64 {
+	announceDur := uint64(s.AnnounceS) * timescale
+	times := make([]uint64, 0, s.Repeats)
+	for k := 0; k < s.Repeats; k++ {
+		before := announceDur - uint64(k)*announceDur/uint64(s.Repeats)
+		if before > spliceTime {
+			continue
+		}
+		times = append(times, spliceTime-before)
+	}
+	return times
+}
+
+// SpliceEvent is a splice announced in a segment.
+type SpliceEvent struct {
+	AdBreak
+	// ID is the splice event ID, which is the splice time in seconds.
+	ID uint32
+}
+
+// SpliceEvents returns the splices that are announced in the segment [segStart, segEnd).
+// A splice is announced in a segment if one of its announce times t fulfills segStart < t <= segEnd.
+func (s Schedule) SpliceEvents(segStart, segEnd, timescale uint64) []SpliceEvent {
+	announceDur := uint64(s.AnnounceS) * timescale
+	var events []SpliceEvent
+	for _, b := range s.AdBreaks(segStart+1, segEnd+announceDur+1, timescale) {
+		for _, t := range s.announceTimes(b.Start, timescale) {
+			if segStart < t && t <= segEnd {
+				events = append(events, SpliceEvent{AdBreak: b, ID: uint32(b.Start / timescale)})
+				break
+			}
+		}
+	}
+	return events
+}
+
+// CueInEvents returns the ad break ends that are announced in the segment [segStart, segEnd).
+// The end of a break is announced like its start, but relative to the end time.
+func (s Schedule) CueInEvents(segStart, segEnd, timescale uint64) []SpliceEvent {
+	announceDur := uint64(s.AnnounceS) * timescale
+	maxBreakDur := s.maxBreakDur(timescale)
+	searchStart := uint64(0)
+	if segStart+1 > maxBreakDur {
+		searchStart = segStart + 1 - maxBreakDur
+	}
+	var events []SpliceEvent
+	for _, b := range s.AdBreaks(searchStart, segEnd+announceDur+1, timescale) {
+		end := b.End()
+		if end <= segStart || end > segEnd+announceDur {
+			continue
+		}
+		for _, t := range s.announceTimes(end, timescale) {
+			if segStart < t && t <= segEnd {
+				events = append(events, SpliceEvent{AdBreak: b, ID: uint32(b.Start / timescale)})
+				break
+			}
+		}
+	}
+	return events
+}
+
+// maxBreakDur returns the duration of the longest break in timescale units.
+func (s Schedule) maxBreakDur(timescale uint64) uint64 {
+	maxDur := uint64(0)
+	for _, b := range s.Breaks {
+		maxDur = max(maxDur, uint64(b.DurationS)*timescale)
 	}
-	emsgID := spliceTime / timescale
+	return maxDur
+}
+
+// CreateEmsgs generates SCTE-35 splice_insert emsg boxes for all splices announced in the segment.
+func (s Schedule) CreateEmsgs(segStart, segEnd, timescale uint64) []*mp4.EmsgBox {
+	events := s.SpliceEvents(segStart, segEnd, timescale)
+	emsgs := make([]*mp4.EmsgBox, 0, len(events))
+	for _, ev := range events {
+		emsgs = append(emsgs, spliceInsertEmsg(ev, timescale))
+	}
+	return emsgs
+}
+
+// spliceInsertEmsg returns an emsg box with a splice_insert for the splice event.
+func spliceInsertEmsg(ev SpliceEvent, timescale uint64) *mp4.EmsgBox {
 	p := SpliceInsertParams{
-		PtsTime:                    uint64(spliceTime*90000/timescale) % (1 << 33),
-		Duration:                   uint64(adDuration * 90000 / timescale),
-		SpliceEventID:              uint32(emsgID),
+		PtsTime:                    uint64(ev.Start*90000/timescale) % (1 << 33),
+		Duration:                   uint64(ev.Duration * 90000 / timescale),
+		SpliceEventID:              ev.ID,
 		Tier:                       4095,
 		UniqueProgramID:            0,
 		AvailNum:                   0,
@@ -73,18 +258,40 @@ func CreateEmsgAhead(segStart, segEnd, timescale uint64, perMinute int) (*mp4.Em
 		SpliceImmediateFlag:        false,
 		AutoReturn:                 true,
 	}
-	e := mp4.EmsgBox{
+	return &mp4.EmsgBox{
 		Version:          1,
 		Flags:            0,
 		TimeScale:        uint32(timescale),
-		PresentationTime: uint64(spliceTime),
-		EventDuration:    uint32(adDuration),
-		ID:               uint32(emsgID),
+		PresentationTime: ev.Start,
+		EventDuration:    uint32(ev.Duration),
+		ID:               ev.ID,
 		SchemeIDURI:      SchemeIDURI,
 		Value:            "",
 		MessageData:      CreateSpliceInsertPayload(p),
 	}
-	return &e, nil
+}
+
+// CreateEmsgAhead generates an emsg SCTE-35 box if the the segment covers the time 7s before the ad start.
+// The splice inserts are given by PerMinuteSchedule(perMinute).
+func CreateEmsgAhead(segStart, segEnd, timescale uint64, perMinute int) (*mp4.EmsgBox, error) {
+	s, err := PerMinuteSchedule(perMinute)
+	if err != nil {
+		return nil, err
+	}
+	emsgs := s.CreateEmsgs(segStart, segEnd, timescale)
+	if len(emsgs) == 0 {
+		return nil, nil
+	}
+	return emsgs[0], nil
+}
+
+// AdBreaks returns the ad breaks of PerMinuteSchedule(perMinute) starting in the interval [start, end).
+func AdBreaks(start, end, timescale uint64, perMinute int) ([]AdBreak, error) {
+	s, err := PerMinuteSchedule(perMinute)
+	if err != nil {
+		return nil, err
+	}
+	return s.AdBreaks(start, end, timescale), nil
 }
 
 type SpliceInsertParams struct {
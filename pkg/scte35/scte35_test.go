@@ -69,3 +69,108 @@ func TestSCTE35Generation(t *testing.T) {
 		}
 	}
 }
+
+func TestAdBreaks(t *testing.T) {
+	testCases := []struct {
+		start, end   uint64
+		timescale    uint64
+		perMinute    int
+		wantedBreaks []scte35.AdBreak
+		expectedErr  bool
+	}{
+		{
+			start:        0,
+			end:          120,
+			timescale:    1,
+			perMinute:    1,
+			wantedBreaks: []scte35.AdBreak{{Start: 10, Duration: 20}, {Start: 70, Duration: 20}},
+		},
+		{
+			start:        45,
+			end:          100,
+			timescale:    1,
+			perMinute:    3,
+			wantedBreaks: []scte35.AdBreak{{Start: 46, Duration: 10}, {Start: 70, Duration: 10}, {Start: 96, Duration: 10}},
+		},
+		{
+			start:        11 * 90000,
+			end:          40 * 90000,
+			timescale:    90000,
+			perMinute:    2,
+			wantedBreaks: nil,
+		},
+		{
+			perMinute:   0,
+			expectedErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		breaks, err := scte35.AdBreaks(tc.start, tc.end, tc.timescale, tc.perMinute)
+		if tc.expectedErr {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, tc.wantedBreaks, breaks)
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	testCases := []struct {
+		pattern     string
+		wanted      scte35.Schedule
+		expectedErr bool
+	}{
+		{
+			pattern: "period:900,offset:60,dur:120,announce:8,repeats:3",
+			wanted: scte35.Schedule{PeriodS: 900, Breaks: []scte35.Break{{OffsetS: 60, DurationS: 120}},
+				AnnounceS: 8, Repeats: 3},
+		},
+		{
+			pattern: "period:60,dur:10",
+			wanted: scte35.Schedule{PeriodS: 60, Breaks: []scte35.Break{{OffsetS: 0, DurationS: 10}},
+				AnnounceS: scte35.DefaultAnnounceS, Repeats: 1},
+		},
+		{pattern: "period:60", expectedErr: true},
+		{pattern: "period:60,offset:55,dur:10", expectedErr: true},
+		{pattern: "period:60,dur:10,repeats:8", expectedErr: true},
+		{pattern: "period:60,dur:10,color:1", expectedErr: true},
+		{pattern: "period:x,dur:10", expectedErr: true},
+		{pattern: "period60", expectedErr: true},
+	}
+	for _, tc := range testCases {
+		s, err := scte35.ParseSchedule(tc.pattern)
+		if tc.expectedErr {
+			assert.Error(t, err, tc.pattern)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, tc.wanted, s)
+	}
+}
+
+func TestScheduleEmsgRepeats(t *testing.T) {
+	s, err := scte35.ParseSchedule("period:900,offset:60,dur:120,announce:8,repeats:4")
+	require.NoError(t, err)
+	timescale := uint64(1000)
+	segDur := uint64(2000)
+	var segStartsWithEmsg []uint64
+	for segStart := uint64(40_000); segStart < 1_000_000; segStart += segDur {
+		emsgs := s.CreateEmsgs(segStart, segStart+segDur, timescale)
+		if len(emsgs) == 0 {
+			continue
+		}
+		require.Len(t, emsgs, 1)
+		e := emsgs[0]
+		spliceS := uint64(60)
+		if segStart > 100_000 {
+			spliceS = 960
+		}
+		assert.Equal(t, uint32(spliceS), e.ID)
+		assert.Equal(t, spliceS*timescale, e.PresentationTime)
+		assert.Equal(t, uint32(120_000), e.EventDuration)
+		segStartsWithEmsg = append(segStartsWithEmsg, segStart)
+	}
+	wanted := []uint64{50_000, 52_000, 54_000, 56_000, 950_000, 952_000, 954_000, 956_000}
+	assert.Equal(t, wanted, segStartsWithEmsg)
+}
@@ -0,0 +1,228 @@
+package scte35
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/Comcast/gots/v2"
+	"github.com/Comcast/gots/v2/scte35"
+	"github.com/Eyevinn/mp4ff/mp4"
+)
+
+const (
+	upidTypeURI           = 0x0f
+	segmentationTimescale = 90000
+	maxSegmentationDur90k = 1<<40 - 1
+)
+
+// Segmentation type IDs (SCTE-35 Table 23) for the start of matched start/end pairs.
+// The end type ID is always the start type ID + 1.
+const (
+	SegTypeProgramStart       uint8 = 0x10
+	SegTypeProviderAdStart    uint8 = 0x30
+	SegTypeDistributorAdStart uint8 = 0x32
+	SegTypeProviderPOStart    uint8 = 0x34
+	SegTypeDistributorPOStart uint8 = 0x36
+)
+
+// SegmentationStartTypes maps command names used in configurations to start segmentation type IDs.
+var SegmentationStartTypes = map[string]uint8{
+	"program":       SegTypeProgramStart,
+	"providerad":    SegTypeProviderAdStart,
+	"distributorad": SegTypeDistributorAdStart,
+	"providerpo":    SegTypeProviderPOStart,
+	"distributorpo": SegTypeDistributorPOStart,
+}
+
+// SegmentationDescriptor is a segmentation_descriptor for a whole program (no components).
+type SegmentationDescriptor struct {
+	EventID uint32
+	TypeID  uint8
+	// Duration is in 90kHz ticks. 0 means no duration.
+	Duration         uint64
+	UPIDType         uint8
+	UPID             []byte
+	SegmentNum       uint8
+	SegmentsExpected uint8
+	// SubSegmentNum and SubSegmentsExpected are only sent for placement opportunity types 0x34 and 0x36.
+	SubSegmentNum       uint8
+	SubSegmentsExpected uint8
+}
+
+// TimeSignalParams are the parameters of a splice_info_section with a time_signal command.
+type TimeSignalParams struct {
+	PtsTime     uint64
+	Tier        uint16
+	Descriptors []SegmentationDescriptor
+}
+
+// CreateTimeSignalPayload creates a SCTE-35 splice_info_section with a time_signal command
+// and segmentation descriptors including CRC.
+func CreateTimeSignalPayload(p TimeSignalParams) ([]byte, error) {
+	descs := make([]scte35.SegmentationDescriptor, 0, len(p.Descriptors))
+	for _, d := range p.Descriptors {
+		desc, err := createSegmentationDescriptor(d)
+		if err != nil {
+			return nil, err
+		}
+		descs = append(descs, desc)
+	}
+	s := scte35.CreateSCTE35()
+	s.SetTier(p.Tier)
+	cmd := scte35.CreateTimeSignalCommand()
+	s.SetCommandInfo(cmd)
+	s.SetHasPTS(true)
+	s.SetPTS(gots.PTS(p.PtsTime % (1 << 33)))
+	s.SetDescriptors(descs)
+	return s.UpdateData(), nil
+}
+
+// createSegmentationDescriptor returns a program segmentation_descriptor without delivery restrictions.
+func createSegmentationDescriptor(d SegmentationDescriptor) (scte35.SegmentationDescriptor, error) {
+	if len(d.UPID) > 255 {
+		return nil, fmt.Errorf("segmentation upid too long: %d bytes", len(d.UPID))
+	}
+	if d.Duration > maxSegmentationDur90k {
+		return nil, fmt.Errorf("segmentation duration %d too big", d.Duration)
+	}
+	desc := scte35.CreateSegmentationDescriptor()
+	desc.SetEventID(d.EventID)
+	desc.SetHasProgramSegmentation(true)
+	desc.SetIsDeliveryNotRestricted(true)
+	if d.Duration > 0 {
+		desc.SetHasDuration(true)
+		desc.SetDuration(gots.PTS(d.Duration))
+	}
+	desc.SetUPIDType(scte35.SegUPIDType(d.UPIDType))
+	desc.SetUPID(d.UPID)
+	desc.SetTypeID(scte35.SegDescType(d.TypeID))
+	desc.SetSegmentNumber(d.SegmentNum)
+	desc.SetSegmentsExpected(d.SegmentsExpected)
+	if d.TypeID == SegTypeProviderPOStart || d.TypeID == SegTypeDistributorPOStart {
+		desc.SetHasSubSegments(true)
+		desc.SetSubSegmentNumber(d.SubSegmentNum)
+		desc.SetSubSegmentsExpected(d.SubSegmentsExpected)
+	}
+	return desc, nil
+}
+
+// breakUPID returns a URI UPID identifying the ad break starting at startS.
+func breakUPID(startS uint64) []byte {
+	return []byte(fmt.Sprintf("urn:livesim2:break:%d", startS))
+}
+
+// CreateTimeSignalEmsgs generates emsg boxes with time_signal commands for all cue-outs and cue-ins
+// announced in the segment [segStart, segEnd).
+// The cue-out has a segmentation descriptor of type startType with the break duration,
+// and the cue-in one of type startType+1 with the same segmentation event ID.
+// The emsg IDs are given by timeSignalEmsgIDs.
+func (s Schedule) CreateTimeSignalEmsgs(segStart, segEnd, timescale uint64, startType uint8) ([]*mp4.EmsgBox, error) {
+	var emsgs []*mp4.EmsgBox
+	for _, ev := range s.SpliceEvents(segStart, segEnd, timescale) {
+		e, err := cueOutTimeSignalEmsg(ev, startType, timescale)
+		if err != nil {
+			return nil, err
+		}
+		emsgs = append(emsgs, e)
+	}
+	for _, ev := range s.CueInEvents(segStart, segEnd, timescale) {
+		e, err := cueInTimeSignalEmsg(ev, startType, timescale)
+		if err != nil {
+			return nil, err
+		}
+		emsgs = append(emsgs, e)
+	}
+	return emsgs, nil
+}
+
+// CueEmsgs returns the emsg boxes for all cues with splice time in [start, end) ordered by time.
+// The boxes are the same as the ones sent in-band in the segments, but independent of when they are announced.
+// A startType of 0 gives splice_insert commands. Otherwise, time_signal commands are generated
+// for both cue-outs and cue-ins as in CreateTimeSignalEmsgs.
+func (s Schedule) CueEmsgs(start, end, timescale uint64, startType uint8) ([]*mp4.EmsgBox, error) {
+	var emsgs []*mp4.EmsgBox
+	for _, b := range s.AdBreaks(start, end, timescale) {
+		ev := SpliceEvent{AdBreak: b, ID: uint32(b.Start / timescale)}
+		if startType == 0 {
+			emsgs = append(emsgs, spliceInsertEmsg(ev, timescale))
+			continue
+		}
+		e, err := cueOutTimeSignalEmsg(ev, startType, timescale)
+		if err != nil {
+			return nil, err
+		}
+		emsgs = append(emsgs, e)
+	}
+	if startType == 0 {
+		return emsgs, nil
+	}
+	searchStart := start - min(start, s.maxBreakDur(timescale))
+	for _, b := range s.AdBreaks(searchStart, end, timescale) {
+		if b.End() < start || b.End() >= end {
+			continue
+		}
+		e, err := cueInTimeSignalEmsg(SpliceEvent{AdBreak: b, ID: uint32(b.Start / timescale)}, startType, timescale)
+		if err != nil {
+			return nil, err
+		}
+		emsgs = append(emsgs, e)
+	}
+	sort.SliceStable(emsgs, func(i, j int) bool {
+		return emsgs[i].PresentationTime < emsgs[j].PresentationTime
+	})
+	return emsgs, nil
+}
+
+// cueOutTimeSignalEmsg returns the time_signal emsg box for the start of the splice event.
+func cueOutTimeSignalEmsg(ev SpliceEvent, startType uint8, timescale uint64) (*mp4.EmsgBox, error) {
+	cueOutID, _ := timeSignalEmsgIDs(ev.Start / timescale)
+	return timeSignalEmsg(ev.Start, ev.Duration, cueOutID, ev.ID, startType, timescale)
+}
+
+// cueInTimeSignalEmsg returns the time_signal emsg box for the end of the splice event.
+func cueInTimeSignalEmsg(ev SpliceEvent, startType uint8, timescale uint64) (*mp4.EmsgBox, error) {
+	_, cueInID := timeSignalEmsgIDs(ev.Start / timescale)
+	return timeSignalEmsg(ev.End(), 0, cueInID, ev.ID, startType+1, timescale)
+}
+
+// timeSignalEmsgIDs returns the emsg IDs for the cue-out and cue-in of the break starting at breakStartS.
+// The cue-out ID is even and the cue-in ID is the following odd number, so that a cue-in never
+// collides with a cue-out, even when a break starts where another ends.
+func timeSignalEmsgIDs(breakStartS uint64) (cueOutID, cueInID uint32) {
+	cueOutID = uint32(2 * breakStartS)
+	return cueOutID, cueOutID + 1
+}
+
+// timeSignalEmsg returns an emsg box with a time_signal at time t with one segmentation descriptor.
+func timeSignalEmsg(t, dur uint64, emsgID, segEventID uint32, typeID uint8, timescale uint64) (*mp4.EmsgBox, error) {
+	p := TimeSignalParams{
+		PtsTime: t * segmentationTimescale / timescale,
+		Tier:    4095,
+		Descriptors: []SegmentationDescriptor{
+			{
+				EventID:          segEventID,
+				TypeID:           typeID,
+				Duration:         dur * segmentationTimescale / timescale,
+				UPIDType:         upidTypeURI,
+				UPID:             breakUPID(uint64(segEventID)),
+				SegmentNum:       1,
+				SegmentsExpected: 1,
+			},
+		},
+	}
+	payload, err := CreateTimeSignalPayload(p)
+	if err != nil {
+		return nil, err
+	}
+	return &mp4.EmsgBox{
+		Version:          1,
+		Flags:            0,
+		TimeScale:        uint32(timescale),
+		PresentationTime: t,
+		EventDuration:    uint32(dur),
+		ID:               emsgID,
+		SchemeIDURI:      SchemeIDURI,
+		Value:            "",
+		MessageData:      payload,
+	}, nil
+}
@@ -0,0 +1,172 @@
+package scte35
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/Comcast/gots/v2/scte35"
+	"github.com/stretchr/testify/require"
+)
+
+// parseSCTE35 parses a splice_info_section including the CRC check.
+func parseSCTE35(payload []byte) (scte35.SCTE35, error) {
+	// gots parses a PSI section with a leading pointer_field
+	return scte35.NewSCTE35(append([]byte{0}, payload...))
+}
+
+func TestCreateTimeSignalPayload(t *testing.T) {
+	// Placement opportunity start similar to SCTE-35 sample 14.2, but with delivery not restricted
+	p := TimeSignalParams{
+		PtsTime: 0x072bd0050,
+		Tier:    4095,
+		Descriptors: []SegmentationDescriptor{
+			{
+				EventID:          0x4800008e,
+				TypeID:           SegTypeProviderPOStart,
+				Duration:         0x0001a599b0,
+				UPIDType:         0x08,
+				UPID:             []byte{0x00, 0x00, 0x00, 0x00, 0x2c, 0xa0, 0xa1, 0x8a},
+				SegmentNum:       2,
+				SegmentsExpected: 0,
+			},
+		},
+	}
+	payload, err := CreateTimeSignalPayload(p)
+	require.NoError(t, err)
+	// sub_segment_num and sub_segments_expected are sent for type 0x34
+	wantedHex := "fc303600000000000000fff00506fe72bd00500020021e435545494800008e7fff0001a599b0080800" +
+		"0000002ca0a18a3402000000"
+	require.Equal(t, wantedHex, hex.EncodeToString(payload[:len(payload)-4]))
+	sig, err := parseSCTE35(payload)
+	require.NoError(t, err, "parse including CRC check")
+	require.Equal(t, scte35.SpliceCommandType(scte35.TimeSignal), sig.Command())
+	require.Equal(t, uint64(0x072bd0050), uint64(sig.PTS()))
+	descs := sig.Descriptors()
+	require.Len(t, descs, 1)
+	require.Equal(t, uint32(0x4800008e), descs[0].EventID())
+	require.Equal(t, scte35.SegDescType(SegTypeProviderPOStart), descs[0].TypeID())
+	require.Equal(t, uint64(0x0001a599b0), uint64(descs[0].Duration()))
+	require.True(t, descs[0].HasSubSegments())
+	require.Equal(t, uint8(2), descs[0].SegmentNumber())
+
+	p.Descriptors[0].TypeID = SegTypeProviderAdStart
+	payload, err = CreateTimeSignalPayload(p)
+	require.NoError(t, err)
+	sig, err = parseSCTE35(payload)
+	require.NoError(t, err)
+	require.False(t, sig.Descriptors()[0].HasSubSegments(), "no sub segments for other types")
+
+	p.Descriptors[0].UPID = make([]byte, 256)
+	_, err = CreateTimeSignalPayload(p)
+	require.Error(t, err)
+}
+
+func TestCreateTimeSignalEmsgs(t *testing.T) {
+	s, err := ParseSchedule("period:60,offset:10,dur:20")
+	require.NoError(t, err)
+	timescale := uint64(1000)
+	// First break starting after 2^31 s, where the break start in seconds has the MSB set
+	lateBreakS := uint64(10 + 60*35791394)
+	testCases := []struct {
+		desc         string
+		segStart     uint64
+		wantedID     uint32
+		wantedTime   uint64
+		wantedDur    uint32
+		wantedTypeID uint8
+	}{
+		{desc: "cue-out", segStart: 2000, wantedID: 20, wantedTime: 10_000, wantedDur: 20_000, wantedTypeID: 0x36},
+		{desc: "cue-in", segStart: 22_000, wantedID: 21, wantedTime: 30_000, wantedDur: 0, wantedTypeID: 0x37},
+		{desc: "next cue-out", segStart: 62_000, wantedID: 140, wantedTime: 70_000, wantedDur: 20_000, wantedTypeID: 0x36},
+		{desc: "cue-out after 2^31s", segStart: (lateBreakS - 8) * 1000, wantedID: 4, wantedTime: lateBreakS * 1000,
+			wantedDur: 20_000, wantedTypeID: 0x36},
+		{desc: "cue-in after 2^31s", segStart: (lateBreakS + 12) * 1000, wantedID: 5, wantedTime: (lateBreakS + 20) * 1000,
+			wantedDur: 0, wantedTypeID: 0x37},
+		{desc: "none", segStart: 4000},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			emsgs, err := s.CreateTimeSignalEmsgs(tc.segStart, tc.segStart+2000, timescale, SegTypeDistributorPOStart)
+			require.NoError(t, err)
+			if tc.wantedID == 0 {
+				require.Len(t, emsgs, 0)
+				return
+			}
+			require.Len(t, emsgs, 1)
+			e := emsgs[0]
+			require.Equal(t, tc.wantedID, e.ID)
+			require.Equal(t, tc.wantedTime, e.PresentationTime)
+			require.Equal(t, tc.wantedDur, e.EventDuration)
+			require.Equal(t, SchemeIDURI, e.SchemeIDURI)
+			sig, err := parseSCTE35(e.MessageData)
+			require.NoError(t, err)
+			require.Equal(t, scte35.SpliceCommandType(scte35.TimeSignal), sig.Command())
+			require.Equal(t, tc.wantedTime*segmentationTimescale/timescale%(1<<33), uint64(sig.PTS()))
+			descs := sig.Descriptors()
+			require.Len(t, descs, 1)
+			require.Equal(t, scte35.SegDescType(tc.wantedTypeID), descs[0].TypeID(), "segmentation_type_id")
+		})
+	}
+}
+
+func TestTimeSignalEmsgIDs(t *testing.T) {
+	testCases := []struct {
+		breakStartS    uint64
+		wantedCueOutID uint32
+	}{
+		{breakStartS: 0, wantedCueOutID: 0},
+		{breakStartS: 30, wantedCueOutID: 60},
+		{breakStartS: 1<<31 - 1, wantedCueOutID: 1<<32 - 2},
+		{breakStartS: 1 << 31, wantedCueOutID: 0},
+		{breakStartS: 1<<31 + 30, wantedCueOutID: 60},
+	}
+	for _, tc := range testCases {
+		cueOutID, cueInID := timeSignalEmsgIDs(tc.breakStartS)
+		require.Equal(t, tc.wantedCueOutID, cueOutID)
+		require.Equal(t, cueOutID+1, cueInID)
+	}
+	// A break starting where another ends must not get the cue-in ID of the other break
+	_, cueInID := timeSignalEmsgIDs(1<<31 + 10)
+	cueOutID, _ := timeSignalEmsgIDs(1<<31 + 30)
+	require.NotEqual(t, cueInID, cueOutID)
+}
+
+func TestCueEmsgs(t *testing.T) {
+	s, err := ParseSchedule("period:60,offset:10,dur:20")
+	require.NoError(t, err)
+	timescale := uint64(1000)
+	testCases := []struct {
+		desc      string
+		start     uint64
+		end       uint64
+		startType uint8
+		wantedIDs []uint32
+	}{
+		{desc: "splice_insert", start: 0, end: 120_000, wantedIDs: []uint32{10, 70}},
+		{desc: "time_signal", start: 0, end: 120_000, startType: SegTypeProviderAdStart,
+			wantedIDs: []uint32{20, 21, 140, 141}},
+		{desc: "cue-in only", start: 20_000, end: 60_000, startType: SegTypeProviderAdStart,
+			wantedIDs: []uint32{21}},
+		{desc: "end exclusive", start: 30_001, end: 70_000, startType: SegTypeProviderAdStart},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			emsgs, err := s.CueEmsgs(tc.start, tc.end, timescale, tc.startType)
+			require.NoError(t, err)
+			ids := make([]uint32, 0, len(emsgs))
+			for _, e := range emsgs {
+				ids = append(ids, e.ID)
+			}
+			require.Equal(t, len(tc.wantedIDs), len(ids))
+			for i, id := range tc.wantedIDs {
+				require.Equal(t, id, ids[i])
+			}
+		})
+	}
+	inband, err := s.CreateTimeSignalEmsgs(2000, 4000, timescale, SegTypeProviderAdStart)
+	require.NoError(t, err)
+	cues, err := s.CueEmsgs(10_000, 11_000, timescale, SegTypeProviderAdStart)
+	require.NoError(t, err)
+	require.Len(t, cues, 1)
+	require.Equal(t, inband[0].MessageData, cues[0].MessageData, "same payload as in-band")
+}